@@ -0,0 +1,246 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionValid reports whether the spec passed validation
+	ConditionValid = "Valid"
+	// ConditionApplied reports whether the HAProxy configuration built from
+	// the spec was published
+	ConditionApplied = "Applied"
+)
+
+// MonitorPort is a port which is probed for a cluster certificate.
+type MonitorPort struct {
+	Port       int64  `json:"port,omitempty"`
+	Name       string `json:"name,omitempty"`
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	PathMatch  string `json:"pathMatch,omitempty"`
+	// Protocol is how the port is probed
+	// +kubebuilder:validation:Enum=tcp;tls;http;https
+	Protocol string `json:"protocol,omitempty"`
+	// SNI is the server name sent in the TLS handshake of tls and https probes
+	SNI string `json:"sni,omitempty"`
+	// Rise is the number of consecutive successful probes before an address
+	// is routed
+	// +kubebuilder:validation:Minimum=0
+	Rise int `json:"rise,omitempty"`
+	// Fall is the number of consecutive failed probes before an address is no
+	// longer routed
+	// +kubebuilder:validation:Minimum=0
+	Fall int `json:"fall,omitempty"`
+	// HoldTime is the minimum time in milliseconds an address stays routed, or
+	// not routed, before it can change again
+	// +kubebuilder:validation:Minimum=0
+	HoldTime int `json:"holdTime,omitempty"`
+	// DomainRules are regular expressions with a domain capture group which
+	// extract the base domain from the names in the certificate served
+	DomainRules []string `json:"domainRules,omitempty"`
+}
+
+// MonitorRange is a range of addresses which is scanned for clusters.
+type MonitorRange struct {
+	IPAddressStart string                 `json:"ipAddressStart,omitempty"`
+	IPAddressEnd   string                 `json:"ipAddressEnd,omitempty"`
+	IPCIDR         string                 `json:"ipCIDR,omitempty"`
+	Exclude        []string               `json:"exclude,omitempty"`
+	PortProfile    string                 `json:"portProfile,omitempty"`
+	PortOverrides  map[string]MonitorPort `json:"portOverrides,omitempty"`
+	MonitorPorts   []MonitorPort          `json:"monitorPorts,omitempty"`
+	// ProbesPerSecond limits the rate at which the range is probed, overriding
+	// the scan default
+	// +kubebuilder:validation:Minimum=0
+	ProbesPerSecond int `json:"probesPerSecond,omitempty"`
+	// ExpectedDomains are regular expressions one of which the whole of each
+	// base domain found in the range must match
+	ExpectedDomains []string `json:"expectedDomains,omitempty"`
+}
+
+// JobIdentity is where the job identity of a pod is read from.
+type JobIdentity struct {
+	// Source is one of env, label or annotation
+	// +kubebuilder:validation:Enum=env;label;annotation
+	Source string `json:"source,omitempty"`
+	// Name is the env var, label or annotation holding the job identity
+	Name string `json:"name,omitempty"`
+}
+
+// NamespaceRules selects the namespaces whose pods are tracked as clusters and
+// how the domain of each cluster is derived.
+type NamespaceRules struct {
+	Prefix        string      `json:"prefix,omitempty"`
+	Regex         string      `json:"regex,omitempty"`
+	LabelSelector string      `json:"labelSelector,omitempty"`
+	JobIdentity   JobIdentity `json:"jobIdentity,omitempty"`
+	// ClusterDomainTemplate is a Go template for the domain of a cluster
+	ClusterDomainTemplate string `json:"clusterDomainTemplate,omitempty"`
+	// LookupHostTemplate is a Go template for the host resolved to find the
+	// VIPs of a cluster
+	LookupHostTemplate string `json:"lookupHostTemplate,omitempty"`
+}
+
+// ScanSettings limits the load a scan puts on the network.
+type ScanSettings struct {
+	// Concurrency is the maximum number of probes in flight across all ranges
+	// +kubebuilder:validation:Minimum=0
+	Concurrency int `json:"concurrency,omitempty"`
+	// ProbesPerSecond is the maximum rate at which each range is probed
+	// +kubebuilder:validation:Minimum=0
+	ProbesPerSecond int `json:"probesPerSecond,omitempty"`
+	// Deadline is the time in milliseconds a whole scan may take
+	// +kubebuilder:validation:Minimum=0
+	Deadline int `json:"deadline,omitempty"`
+	// FullScanInterval is the time in milliseconds between scans of every
+	// address of a range when an inventory is kept, 15 minutes if it is not
+	// set
+	// +kubebuilder:validation:Minimum=0
+	FullScanInterval int `json:"fullScanInterval,omitempty"`
+}
+
+// DomainPolicy restricts the base domains found by scans which are routed.
+type DomainPolicy struct {
+	// AllowedSuffixes are the domains under which a base domain must be, if
+	// any are set
+	AllowedSuffixes []string `json:"allowedSuffixes,omitempty"`
+	// DeniedDomains are the domains which, along with their subdomains, are
+	// never routed
+	DeniedDomains []string `json:"deniedDomains,omitempty"`
+}
+
+// SNIRouting sets how the frontends route connections to the backend of the
+// cluster named by their SNI.
+type SNIRouting struct {
+	// Mode is rules, a use_backend rule for each port of each cluster, or
+	// map, a lookup in map files shipped in the config map
+	// +kubebuilder:validation:Enum=rules;map
+	Mode string `json:"mode,omitempty"`
+	// MapDirectory is the directory HAProxy reads the map files from
+	MapDirectory string `json:"mapDirectory,omitempty"`
+}
+
+// RuntimeAPI sets how the targets of backends are updated through the HAProxy
+// Runtime API rather than by restarting HAProxy.
+type RuntimeAPI struct {
+	// Address is the host and port of a stats socket with the admin level.
+	// The Runtime API is only used if it is set.
+	Address string `json:"address,omitempty"`
+	// ServerSlots is how many servers are allocated to a backend at a time
+	// +kubebuilder:validation:Minimum=0
+	ServerSlots int `json:"serverSlots,omitempty"`
+}
+
+// DataPlaneAPI sets how the HAProxy Data Plane API is reached.
+type DataPlaneAPI struct {
+	// URL is the base URL of the API, such as http://haproxy:5555/v2
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	// PasswordFile is the path of a file holding the password of the user
+	PasswordFile string `json:"passwordFile,omitempty"`
+	// Retries is how many times a transaction which conflicts with another
+	// change is retried
+	// +kubebuilder:validation:Minimum=0
+	Retries int `json:"retries,omitempty"`
+}
+
+// Output sets where the HAProxy configuration is published.
+type Output struct {
+	// Type is config-map, the haproxy config map read by the HAProxy
+	// deployment, or data-plane-api
+	// +kubebuilder:validation:Enum=config-map;data-plane-api
+	Type string `json:"type,omitempty"`
+	// Renderer is the proxy the routing table is rendered for, haproxy,
+	// nginx-stream, envoy or traefik
+	// +kubebuilder:validation:Enum=haproxy;nginx-stream;envoy;traefik
+	Renderer     string       `json:"renderer,omitempty"`
+	DataPlaneAPI DataPlaneAPI `json:"dataPlaneAPI,omitempty"`
+}
+
+// DynaConfigSpec defines the desired state of DynaConfig. It mirrors the
+// monitor config file.
+type DynaConfigSpec struct {
+	MonitorRanges        []MonitorRange           `json:"monitorRanges,omitempty"`
+	HaproxyHeader        string                   `json:"haproxyHeader,omitempty"`
+	CheckTimeout         int                      `json:"checkTimeout,omitempty"`
+	SubnetsJSONPath      string                   `json:"subnetsJSONPath,omitempty"`
+	SubnetsPortProfile   string                   `json:"subnetsPortProfile,omitempty"`
+	SubnetsPortOverrides map[string]MonitorPort   `json:"subnetsPortOverrides,omitempty"`
+	PortProfiles         map[string][]MonitorPort `json:"portProfiles,omitempty"`
+	BaseDomain           string                   `json:"baseDomain,omitempty"`
+	NamespaceRules       NamespaceRules           `json:"namespaceRules,omitempty"`
+	Scan                 ScanSettings             `json:"scan,omitempty"`
+	// CertificateExpiryWarningDays is how many days before a certificate
+	// served by a cluster expires a warning is raised
+	// +kubebuilder:validation:Minimum=0
+	CertificateExpiryWarningDays int          `json:"certificateExpiryWarningDays,omitempty"`
+	DomainPolicy                 DomainPolicy `json:"domainPolicy,omitempty"`
+	// ConflictResolution is how a port of a base domain claimed by more than
+	// one source is routed
+	// +kubebuilder:validation:Enum=pin-first;newest-wins;refuse
+	ConflictResolution string     `json:"conflictResolution,omitempty"`
+	SNIRouting         SNIRouting `json:"sniRouting,omitempty"`
+	RuntimeAPI         RuntimeAPI `json:"runtimeAPI,omitempty"`
+	Output             Output     `json:"output,omitempty"`
+}
+
+// DiscoveredPort is a port of a discovered cluster and the addresses serving it.
+type DiscoveredPort struct {
+	Port    int64    `json:"port"`
+	Targets []string `json:"targets,omitempty"`
+}
+
+// DiscoveredCluster is a cluster routed by the HAProxy configuration.
+type DiscoveredCluster struct {
+	BaseDomain string           `json:"baseDomain"`
+	Ports      []DiscoveredPort `json:"ports,omitempty"`
+}
+
+// DomainConflict is a port of a base domain claimed by more than one source.
+type DomainConflict struct {
+	BaseDomain string   `json:"baseDomain"`
+	Port       int64    `json:"port"`
+	Sources    []string `json:"sources,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	// Winner is the source which is routed, or empty if none is
+	Winner string `json:"winner,omitempty"`
+}
+
+// DynaConfigStatus defines the observed state of DynaConfig
+type DynaConfigStatus struct {
+	ObservedGeneration    int64               `json:"observedGeneration,omitempty"`
+	DiscoveredClusters    []DiscoveredCluster `json:"discoveredClusters,omitempty"`
+	Conflicts             []DomainConflict    `json:"conflicts,omitempty"`
+	LastAppliedConfigHash string              `json:"lastAppliedConfigHash,omitempty"`
+	LastReconcileTime     *metav1.Time        `json:"lastReconcileTime,omitempty"`
+	Conditions            []metav1.Condition  `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=dync
+// +kubebuilder:printcolumn:name="Valid",type=string,JSONPath=`.status.conditions[?(@.type=="Valid")].status`
+// +kubebuilder:printcolumn:name="Applied",type=string,JSONPath=`.status.conditions[?(@.type=="Applied")].status`
+// +kubebuilder:printcolumn:name="Last Reconcile",type=date,JSONPath=`.status.lastReconcileTime`
+
+// DynaConfig is the Schema for the dynaconfigs API
+type DynaConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DynaConfigSpec   `json:"spec,omitempty"`
+	Status DynaConfigStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DynaConfigList contains a list of DynaConfig
+type DynaConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DynaConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DynaConfig{}, &DynaConfigList{})
+}
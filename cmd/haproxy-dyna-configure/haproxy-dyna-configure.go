@@ -1,33 +1,30 @@
 package main
 
 import (
-	"context"
 	"os"
 
-	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
 	controller "github.com/openshift-splat-team/haproxy-dyna-configure/pkg/controller"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
-	ctx := context.TODO()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		case "show-config":
+			os.Exit(showConfig(os.Args[2:]))
+		}
+	}
+
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.DebugLevel)
-	err := pkg.Initialize(ctx)
-	if err != nil {
-		log.Errorf("unable to initialize %s", err)
-		return
+
+	if len(os.Args) > 1 && os.Args[1] == "standalone" {
+		os.Exit(standalone(os.Args[2:]))
 	}
 
+	// the manager loads the monitor config, either from the config file or
+	// from a DynaConfig
 	controller.StartManager()
-	/*	cfg, err := pkg.CheckRanges(ctx)
-		if err != nil {
-			log.Errorf("unable to check ranges %s", err)
-			return
-		}*/
-	/*err = pkg.BuildDynamicConfiguration(cfg)
-	if err != nil {
-		log.Errorf("unable to apply configuration %s", err)
-		return
-	}*/
 }
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-yaml/yaml"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
+)
+
+// showConfig prints the effective monitor config, with subnets json ranges
+// imported and port profiles expanded. It returns the exit code for the process.
+func showConfig(args []string) int {
+	flags := flag.NewFlagSet("show-config", flag.ExitOnError)
+	configPath := flags.String("f", pkg.ConfigPath(), "path of the monitor config to show")
+	_ = flags.Parse(args)
+
+	config, err := pkg.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		return 1
+	}
+
+	out, err := yaml.Marshal(&data.MonitorConfigSpec{MonitorConfig: *config})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to marshal config: %v\n", err)
+		return 1
+	}
+	fmt.Print(string(out))
+	return 0
+}
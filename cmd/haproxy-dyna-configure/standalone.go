@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// standalone scans the monitor ranges on an interval and renders the HAProxy
+// configuration to a file, reloading HAProxy when it changes. It returns the
+// exit code for the process.
+func standalone(args []string) int {
+	flags := flag.NewFlagSet("standalone", flag.ExitOnError)
+	configPath := flags.String("f", pkg.ConfigPath(), "path of the monitor config")
+	outputPath := flags.String("o", "/etc/haproxy/haproxy.cfg", "path the HAProxy configuration is written to")
+	interval := flags.Duration("interval", time.Minute, "time between scans")
+	pidfile := flags.String("pidfile", "", "pidfile of the HAProxy master process, which is sent SIGUSR2 to reload")
+	reloadSignal := flags.String("reload-signal", "USR2", "signal sent to the process in the pidfile to reload, USR2 for HAProxy or HUP for nginx")
+	masterSocket := flags.String("master-socket", "", "HAProxy master CLI socket, which is sent the reload command")
+	inventoryPath := flags.String("inventory", "", "file the addresses found by scans are remembered in")
+	metricsAddress := flags.String("metrics-address", "", "address the Prometheus metrics are served on, such as :8080")
+	_ = flags.Parse(args)
+
+	if len(*pidfile) > 0 && len(*masterSocket) > 0 {
+		fmt.Fprintln(os.Stderr, "only one of -pidfile and -master-socket can be set")
+		return 1
+	}
+
+	daemon := &pkg.Standalone{
+		ConfigPath: *configPath,
+		OutputPath: *outputPath,
+		Interval:   *interval,
+	}
+	if len(*pidfile) > 0 {
+		var sig syscall.Signal
+		switch *reloadSignal {
+		case "USR2":
+			sig = syscall.SIGUSR2
+		case "HUP":
+			sig = syscall.SIGHUP
+		default:
+			fmt.Fprintf(os.Stderr, "unknown reload signal %q\n", *reloadSignal)
+			return 1
+		}
+		daemon.Reloader = &pkg.PidfileReloader{Path: *pidfile, Signal: sig}
+	} else if len(*masterSocket) > 0 {
+		daemon.Reloader = &pkg.MasterSocketReloader{Path: *masterSocket}
+	} else {
+		log.Warn("neither -pidfile nor -master-socket is set, HAProxy will not be reloaded")
+	}
+
+	if len(*inventoryPath) > 0 {
+		inventory, err := pkg.NewInventory(&pkg.FileInventoryStore{Path: *inventoryPath})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		daemon.Inventory = inventory
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(*metricsAddress) > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+		server := &http.Server{Addr: *metricsAddress, Handler: mux}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("unable to serve metrics: %v", err)
+			}
+		}()
+		defer server.Close()
+	}
+	if err := daemon.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
+)
+
+// validate checks a monitor config and reports every problem found. It returns
+// the exit code for the process.
+func validate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("f", pkg.ConfigPath(), "path of the monitor config to validate")
+	_ = flags.Parse(args)
+
+	monitorConfig, err := pkg.LoadConfig(*configPath)
+	if err == nil {
+		return lint(*configPath, monitorConfig)
+	}
+
+	var validationErrors pkg.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		return 1
+	}
+	for _, validationError := range validationErrors {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, validationError)
+	}
+	return 1
+}
+
+// lint reports the problems found in the HAProxy configuration built from a
+// valid monitor config, which catches mistakes in the header before HAProxy
+// does. Problems are labelled by whether they are in the header or the dynamic
+// configuration. The configurations of other proxies are only rendered. It
+// returns the exit code for the process.
+func lint(configPath string, monitorConfig *data.MonitorConfig) int {
+	if renderer := monitorConfig.Output.Renderer; len(renderer) > 0 && renderer != pkg.RendererHAProxy {
+		if _, _, err := pkg.Render(monitorConfig); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+			return 1
+		}
+		fmt.Printf("%s is valid\n", configPath)
+		return 0
+	}
+	model, maps, err := pkg.BuildDynamicModel(monitorConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configPath, err)
+		return 1
+	}
+	code := 0
+	if err := model.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: dynamic configuration: %v\n", configPath, err)
+		code = 1
+	}
+	problems := haproxy.Lint(monitorConfig.HaproxyHeader, model, maps)
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, "%s: haproxy-header %v\n", configPath, problem)
+	}
+	if problems.Err() != nil {
+		code = 1
+	}
+	if code == 0 {
+		fmt.Printf("%s is valid\n", configPath)
+	}
+	return code
+}
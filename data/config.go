@@ -1,27 +1,177 @@
 package data
 
+import "time"
+
 type MonitorPort struct {
-	Port       int64  `yaml:"port"`
-	Name       string `yaml:"name"`
-	Targets    []string
-	PathPrefix string `yaml:"path-prefix"`
-	PathMatch  string `yaml:"path-match"`
-	Protocol   string `yaml:"protocol"`
+	Port       int64    `yaml:"port,omitempty"`
+	Name       string   `yaml:"name,omitempty"`
+	Targets    []string `yaml:"-"`
+	PathPrefix string   `yaml:"path-prefix,omitempty"`
+	PathMatch  string   `yaml:"path-match,omitempty"`
+	// Protocol is how the port is probed, one of tcp, tls, http or https
+	Protocol string `yaml:"protocol,omitempty"`
+	// SNI is the server name sent in the TLS handshake of tls and https probes
+	SNI string `yaml:"sni,omitempty"`
+	// Rise is the number of consecutive successful probes before an address
+	// is routed
+	Rise int `yaml:"rise,omitempty"`
+	// Fall is the number of consecutive failed probes before an address is no
+	// longer routed
+	Fall int `yaml:"fall,omitempty"`
+	// HoldTime is the minimum time in milliseconds an address stays routed, or
+	// not routed, before it can change again
+	HoldTime int `yaml:"hold-time,omitempty"`
+	// DomainRules are regular expressions with a domain capture group which
+	// extract the base domain from the names in the certificate served. They
+	// default to a rule built from PathPrefix or PathMatch.
+	DomainRules []string `yaml:"domain-rules,omitempty"`
+	// TargetIssuers is the issuer of the certificate served by each target
+	TargetIssuers map[string]string `yaml:"-"`
 }
 
 type MonitorRange struct {
-	IpAddressStart string        `yaml:"ip-address-start"`
-	IpAddressEnd   string        `yaml:"ip-address-end"`
-	MonitorPorts   []MonitorPort `yaml:"monitor-ports"`
-	BaseDomain     string
+	IpAddressStart string                 `yaml:"ip-address-start,omitempty"`
+	IpAddressEnd   string                 `yaml:"ip-address-end,omitempty"`
+	IpCidr         string                 `yaml:"ip-cidr,omitempty"`
+	Exclude        []string               `yaml:"exclude,omitempty"`
+	PortProfile    string                 `yaml:"port-profile,omitempty"`
+	PortOverrides  map[string]MonitorPort `yaml:"port-overrides,omitempty"`
+	MonitorPorts   []MonitorPort          `yaml:"monitor-ports"`
+	// ProbesPerSecond limits the rate at which the range is probed, overriding
+	// the scan default
+	ProbesPerSecond int `yaml:"probes-per-second,omitempty"`
+	// ExpectedDomains are regular expressions one of which the whole of each
+	// base domain found in the range must match
+	ExpectedDomains []string `yaml:"expected-domains,omitempty"`
+	BaseDomain      string   `yaml:"-"`
+	// TargetDomains is the base domain found in the certificate of each
+	// address of the range which responded during a scan
+	TargetDomains map[string]string `yaml:"-"`
+	// TargetFirstSeen is when each address of the range was first seen
+	// serving its base domain
+	TargetFirstSeen map[string]time.Time `yaml:"-"`
+	// Source identifies what produced a range which is not scanned, such as
+	// the job of a pod
+	Source string `yaml:"-"`
+	// FirstSeen is when the cluster of a range with a base domain was first
+	// seen
+	FirstSeen time.Time `yaml:"-"`
+}
+
+// JobIdentity is where the job identity of a pod is read from. Source is one
+// of env, label or annotation and Name is the key to read.
+type JobIdentity struct {
+	Source string `yaml:"source,omitempty"`
+	Name   string `yaml:"name,omitempty"`
+}
+
+// NamespaceRules selects the namespaces whose pods are tracked as clusters and
+// how the domain of each cluster is derived. A namespace must match each of
+// Prefix, Regex and LabelSelector which are set.
+type NamespaceRules struct {
+	Prefix        string      `yaml:"prefix,omitempty"`
+	Regex         string      `yaml:"regex,omitempty"`
+	LabelSelector string      `yaml:"label-selector,omitempty"`
+	JobIdentity   JobIdentity `yaml:"job-identity,omitempty"`
+	// ClusterDomainTemplate is a Go template for the domain of a cluster
+	ClusterDomainTemplate string `yaml:"cluster-domain-template,omitempty"`
+	// LookupHostTemplate is a Go template for the host resolved to find the
+	// VIPs of a cluster
+	LookupHostTemplate string `yaml:"lookup-host-template,omitempty"`
+}
+
+// ScanConfig limits the load a scan puts on the network. Zero values use the
+// defaults.
+type ScanConfig struct {
+	// Concurrency is the maximum number of probes in flight across all ranges
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// ProbesPerSecond is the maximum rate at which each range is probed
+	ProbesPerSecond int `yaml:"probes-per-second,omitempty"`
+	// Deadline is the time in milliseconds a whole scan may take
+	Deadline int `yaml:"deadline,omitempty"`
+	// FullScanInterval is the time in milliseconds between scans of every
+	// address of a range when an inventory is kept, 15 minutes if it is not
+	// set. Known addresses are probed by every scan.
+	FullScanInterval int `yaml:"full-scan-interval,omitempty"`
+}
+
+// DomainPolicy restricts the base domains found by scans which are routed. An
+// empty policy allows every domain.
+type DomainPolicy struct {
+	// AllowedSuffixes are the domains under which a base domain must be, if
+	// any are set
+	AllowedSuffixes []string `yaml:"allowed-suffixes,omitempty"`
+	// DeniedDomains are the domains which, along with their subdomains, are
+	// never routed
+	DeniedDomains []string `yaml:"denied-domains,omitempty"`
+}
+
+// SNIRoutingConfig sets how the frontends route connections to the backend of
+// the cluster named by their SNI.
+type SNIRoutingConfig struct {
+	// Mode is rules, a use_backend rule for each port of each cluster, or
+	// map, a lookup in generated map files
+	Mode string `yaml:"mode,omitempty"`
+	// MapDirectory is the directory HAProxy reads the map files from
+	MapDirectory string `yaml:"map-directory,omitempty"`
+}
+
+// RuntimeAPIConfig sets how the targets of backends are updated through the
+// HAProxy Runtime API rather than by a reload.
+type RuntimeAPIConfig struct {
+	// Address is the path of a unix stats socket with the admin level, or
+	// the host and port of a TCP one. The Runtime API is only used if it is
+	// set.
+	Address string `yaml:"address,omitempty"`
+	// ServerSlots is how many servers are allocated to a backend at a time
+	ServerSlots int `yaml:"server-slots,omitempty"`
+}
+
+// DataPlaneAPIConfig sets how the HAProxy Data Plane API is reached.
+type DataPlaneAPIConfig struct {
+	// URL is the base URL of the API, such as http://haproxy:5555/v2
+	URL      string `yaml:"url,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	// PasswordFile is the path of a file holding the password of the user
+	PasswordFile string `yaml:"password-file,omitempty"`
+	// Retries is how many times a transaction which conflicts with another
+	// change is retried
+	Retries int `yaml:"retries,omitempty"`
+}
+
+// OutputConfig sets where the controller publishes the HAProxy
+// configuration and which proxy it is rendered for.
+type OutputConfig struct {
+	// Type is config-map, the haproxy config map read by the HAProxy
+	// deployment, or data-plane-api
+	Type string `yaml:"type,omitempty"`
+	// Renderer is the proxy the routing table is rendered for, haproxy,
+	// nginx-stream, envoy or traefik
+	Renderer     string             `yaml:"renderer,omitempty"`
+	DataPlaneAPI DataPlaneAPIConfig `yaml:"data-plane-api,omitempty"`
 }
 
 type MonitorConfig struct {
-	MonitorRanges []MonitorRange `yaml:"monitor-ranges"`
-	HaproxyHeader string         `yaml:"haproxy-header"`
-	CheckTimeout  int            `yaml:"check-timeout"`
-	SubnetsJson   string         `yaml:"subnets-json-path"`
-	BaseDomain    string         `yaml:"base-domain"`
+	MonitorRanges        []MonitorRange           `yaml:"monitor-ranges"`
+	HaproxyHeader        string                   `yaml:"haproxy-header"`
+	CheckTimeout         int                      `yaml:"check-timeout"`
+	SubnetsJson          string                   `yaml:"subnets-json-path,omitempty"`
+	SubnetsPortProfile   string                   `yaml:"subnets-port-profile,omitempty"`
+	SubnetsPortOverrides map[string]MonitorPort   `yaml:"subnets-port-overrides,omitempty"`
+	PortProfiles         map[string][]MonitorPort `yaml:"port-profiles,omitempty"`
+	BaseDomain           string                   `yaml:"base-domain"`
+	NamespaceRules       NamespaceRules           `yaml:"namespace-rules,omitempty"`
+	Scan                 ScanConfig               `yaml:"scan,omitempty"`
+	// CertificateExpiryWarningDays is how many days before a certificate
+	// served by a cluster expires a warning is raised
+	CertificateExpiryWarningDays int          `yaml:"certificate-expiry-warning-days,omitempty"`
+	DomainPolicy                 DomainPolicy `yaml:"domain-policy,omitempty"`
+	// ConflictResolution is how a port of a base domain claimed by more than
+	// one source is routed, one of pin-first, newest-wins or refuse
+	ConflictResolution string           `yaml:"conflict-resolution,omitempty"`
+	SNIRouting         SNIRoutingConfig `yaml:"sni-routing,omitempty"`
+	RuntimeAPI         RuntimeAPIConfig `yaml:"runtime-api,omitempty"`
+	Output             OutputConfig     `yaml:"output,omitempty"`
 }
 
 type MonitorConfigSpec struct {
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Config is an HAProxy configuration. Sections are serialized in a fixed
+// order, global, defaults, frontends, backends and then listen sections, each
+// in the order they were added.
+type Config struct {
+	Global    *Global
+	Defaults  []*Defaults
+	Frontends []*Frontend
+	Backends  []*Backend
+	Listens   []*Listen
+}
+
+// Frontend returns the frontend with the name, or nil if there is none.
+func (c *Config) Frontend(name string) *Frontend {
+	for _, frontend := range c.Frontends {
+		if frontend.Name == name {
+			return frontend
+		}
+	}
+	return nil
+}
+
+// Backend returns the backend with the name, or nil if there is none.
+func (c *Config) Backend(name string) *Backend {
+	for _, backend := range c.Backends {
+		if backend.Name == name {
+			return backend
+		}
+	}
+	return nil
+}
+
+// Serialize writes the configuration to buf, allocating a buffer if buf is
+// nil.
+func (c *Config) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	if buf == nil {
+		buf = &bytes.Buffer{}
+	}
+	if c.Global != nil {
+		c.Global.Serialize(buf)
+	}
+	for _, defaults := range c.Defaults {
+		defaults.Serialize(buf)
+	}
+	for _, frontend := range c.Frontends {
+		frontend.Serialize(buf)
+	}
+	for _, backend := range c.Backends {
+		backend.Serialize(buf)
+	}
+	for _, listen := range c.Listens {
+		listen.Serialize(buf)
+	}
+	return buf
+}
+
+// Validate checks that every proxy has a unique name, that binds and servers
+// have valid ports and that backend switching rules name a backend of the
+// configuration. Backends chosen by an expression are not checked.
+func (c *Config) Validate() error {
+	problems := []string{}
+	proxies := map[string]string{}
+	addProxy := func(sectionType, name string) {
+		if len(name) == 0 {
+			problems = append(problems, fmt.Sprintf("%s has no name", sectionType))
+			return
+		}
+		if other, exists := proxies[name]; exists {
+			problems = append(problems, fmt.Sprintf("%s %s has the same name as a %s", sectionType, name, other))
+			return
+		}
+		proxies[name] = sectionType
+	}
+	checkBinds := func(sectionType, name string, binds []Bind) {
+		for _, bind := range binds {
+			if bind.Port < 1 || bind.Port > 65535 {
+				problems = append(problems, fmt.Sprintf("%s %s binds to invalid port %d", sectionType, name, bind.Port))
+			}
+		}
+	}
+	checkServers := func(sectionType, name string, servers []Server) {
+		serverNames := map[string]bool{}
+		for _, server := range servers {
+			if len(server.Name) == 0 || len(server.Address) == 0 {
+				problems = append(problems, fmt.Sprintf("%s %s has a server without a name or address", sectionType, name))
+			} else if serverNames[server.Name] {
+				problems = append(problems, fmt.Sprintf("%s %s has more than one server %s", sectionType, name, server.Name))
+			}
+			serverNames[server.Name] = true
+			if server.Port < 1 || server.Port > 65535 {
+				problems = append(problems, fmt.Sprintf("%s %s server %s has invalid port %d", sectionType, name, server.Name, server.Port))
+			}
+		}
+	}
+
+	for _, frontend := range c.Frontends {
+		addProxy(SectionFrontEnd, frontend.Name)
+		checkBinds(SectionFrontEnd, frontend.Name, frontend.Binds)
+	}
+	for _, backend := range c.Backends {
+		addProxy(SectionBackEnd, backend.Name)
+		servers := append([]Server{}, backend.Servers...)
+		for _, template := range backend.ServerTemplates {
+			if template.First < 1 || template.Last < template.First {
+				problems = append(problems, fmt.Sprintf("backend %s server template %s has invalid range %d-%d", backend.Name, template.Prefix, template.First, template.Last))
+				continue
+			}
+			for _, name := range template.Names() {
+				servers = append(servers, Server{Name: name, Address: template.Address, Port: template.Port})
+			}
+		}
+		checkServers(SectionBackEnd, backend.Name, servers)
+	}
+	for _, listen := range c.Listens {
+		addProxy(SectionListen, listen.Name)
+		checkBinds(SectionListen, listen.Name, listen.Binds)
+		checkServers(SectionListen, listen.Name, listen.Servers)
+	}
+	for _, frontend := range c.Frontends {
+		backends := []string{frontend.DefaultBackend}
+		for _, useBackend := range frontend.UseBackends {
+			if !useBackend.Dynamic() {
+				backends = append(backends, useBackend.Backend)
+			}
+		}
+		for _, backend := range backends {
+			if len(backend) > 0 && proxies[backend] != SectionBackEnd && proxies[backend] != SectionListen {
+				problems = append(problems, fmt.Sprintf("frontend %s routes to unknown backend %s", frontend.Name, backend))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid HAProxy configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
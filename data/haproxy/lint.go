@@ -0,0 +1,471 @@
+package data
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is how serious a lint problem is. HAProxy refuses to start with a
+// configuration which has errors, warnings are only suspicious.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// LintProblem is a problem found by Lint at a line of the header.
+type LintProblem struct {
+	Line     int
+	Severity Severity
+	Message  string
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Severity, p.Message)
+}
+
+// LintProblems are the problems found by Lint, ordered by line.
+type LintProblems []LintProblem
+
+// Warnings returns the problems which are warnings.
+func (p LintProblems) Warnings() LintProblems {
+	return p.withSeverity(SeverityWarning)
+}
+
+// Err returns an error listing the problems which are errors, or nil if there
+// are none.
+func (p LintProblems) Err() error {
+	errors := p.withSeverity(SeverityError)
+	if len(errors) == 0 {
+		return nil
+	}
+	messages := []string{}
+	for _, problem := range errors {
+		messages = append(messages, problem.String())
+	}
+	return fmt.Errorf("haproxy-header failed the linter: %s", strings.Join(messages, "; "))
+}
+
+func (p LintProblems) withSeverity(severity Severity) LintProblems {
+	problems := LintProblems{}
+	for _, problem := range p {
+		if problem.Severity == severity {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// lintName matches the characters HAProxy allows in the names of proxies and
+// servers.
+var lintName = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// lintSectionKeywords start a section. Sections other than global are named.
+var lintSectionKeywords = map[string]bool{
+	SectionGlobal:   true,
+	SectionDefaults: true,
+	SectionFrontEnd: true,
+	SectionBackEnd:  true,
+	SectionListen:   true,
+	"peers":         true,
+	"resolvers":     true,
+	"userlist":      true,
+	"cache":         true,
+	"program":       true,
+	"http-errors":   true,
+	"ring":          true,
+	"mailers":       true,
+	"log-forward":   true,
+}
+
+// lintBind is an address and range of ports a section binds to.
+type lintBind struct {
+	line int
+	// address is the address as it is written
+	address string
+	// host is the normalized address or host name
+	host string
+	// addr is the parsed address, invalid if the address is a host name
+	addr      netip.Addr
+	low, high int64
+	v6only    bool
+}
+
+// overlaps reports whether two binds would take the same address and port.
+func (b *lintBind) overlaps(other *lintBind) bool {
+	if b.low > other.high || other.low > b.high {
+		return false
+	}
+	if b.host == other.host {
+		return true
+	}
+	if !b.addr.IsValid() || !other.addr.IsValid() {
+		return false
+	}
+	covers := func(wildcard, addr netip.Addr, v6only bool) bool {
+		if !wildcard.IsUnspecified() {
+			return false
+		}
+		if wildcard.Is4() {
+			return addr.Unmap().Is4()
+		}
+		return !v6only || !addr.Unmap().Is4()
+	}
+	return covers(b.addr, other.addr, b.v6only) || covers(other.addr, b.addr, other.v6only)
+}
+
+type lintSection struct {
+	line    int
+	keyword string
+	name    string
+	// generated is set if the section is one of the model rather than the
+	// header
+	generated bool
+	binds     []lintBind
+	// servers are the names of the servers, including those of server
+	// templates, and the line each is declared on
+	servers map[string]int
+	// backends are the static backends routed to, and the line of each rule
+	backends map[string]int
+}
+
+// frontend reports whether the section accepts connections.
+func (s *lintSection) frontend() bool {
+	return s.keyword == SectionFrontEnd || s.keyword == SectionListen
+}
+
+// backend reports whether the section can be routed to.
+func (s *lintSection) backend() bool {
+	return s.keyword == SectionBackEnd || s.keyword == SectionListen
+}
+
+// Lint checks the hand written header of a configuration whose frontends and
+// backends are those of the model, for the mistakes which stop HAProxy from
+// starting or route connections nowhere. It reports as errors sections and
+// servers of the header with duplicate or invalid names, backend switching
+// rules naming a backend which doesn't exist and binds which collide with
+// another. Backends of the header without servers or which nothing routes to
+// are reported as warnings. Backends named by the map files, keyed by file
+// name, are treated as routed to since they are chosen by map lookups.
+//
+// The model is checked by Config.Validate. Its sections are only checked
+// against those of the header, so every problem is found at a line of the
+// header.
+func Lint(header string, model *Config, maps map[string]string) LintProblems {
+	problems := LintProblems{}
+	addProblem := func(line int, severity Severity, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	sections := []*lintSection{}
+	var section *lintSection
+	for idx, line := range strings.Split(header, "\n") {
+		lineNumber := idx + 1
+		fields := lintFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if lintSectionKeywords[fields[0]] {
+			section = &lintSection{line: lineNumber, keyword: fields[0], servers: map[string]int{}, backends: map[string]int{}}
+			if len(fields) > 1 {
+				section.name = fields[1]
+			}
+			sections = append(sections, section)
+			if section.keyword == SectionGlobal || (section.keyword == SectionDefaults && len(section.name) == 0) {
+				continue
+			}
+			if !lintName.MatchString(section.name) {
+				addProblem(lineNumber, SeverityError, "%s has an invalid name %q", section.keyword, section.name)
+			}
+			continue
+		}
+		if section == nil {
+			addProblem(lineNumber, SeverityError, "%s is outside of a section", fields[0])
+			continue
+		}
+
+		switch {
+		case fields[0] == "bind" && section.frontend() && len(fields) > 1:
+			for _, address := range strings.Split(fields[1], ",") {
+				bind, err := parseLintBind(address, fields[2:])
+				if err != nil {
+					addProblem(lineNumber, SeverityError, "%s %s %v", section.keyword, section.name, err)
+					continue
+				}
+				if bind != nil {
+					bind.line = lineNumber
+					section.binds = append(section.binds, *bind)
+				}
+			}
+		case (fields[0] == "use_backend" || fields[0] == "default_backend") && section.frontend() && len(fields) > 1:
+			// backends chosen by an expression can't be checked
+			if !strings.Contains(fields[1], "%[") {
+				if _, exists := section.backends[fields[1]]; !exists {
+					section.backends[fields[1]] = lineNumber
+				}
+			}
+		case (fields[0] == "server" || fields[0] == "server-template") && section.backend() && len(fields) > 1:
+			names := []string{fields[1]}
+			if fields[0] == "server-template" {
+				var err error
+				if names, err = serverTemplateNames(fields[1], fields[2:]); err != nil {
+					addProblem(lineNumber, SeverityError, "%s %s server template %s %v", section.keyword, section.name, fields[1], err)
+					continue
+				}
+			}
+			for _, name := range names {
+				if !lintName.MatchString(name) {
+					addProblem(lineNumber, SeverityError, "%s %s has a server with an invalid name %q", section.keyword, section.name, name)
+				} else if first, exists := section.servers[name]; exists {
+					addProblem(lineNumber, SeverityError, "%s %s declares server %s again, first at line %d", section.keyword, section.name, name, first)
+					continue
+				}
+				section.servers[name] = lineNumber
+			}
+			// a server tracking another uses its backend
+			for idx := 2; idx < len(fields)-1; idx++ {
+				if fields[idx] == "track" && strings.Contains(fields[idx+1], "/") {
+					section.backends[strings.SplitN(fields[idx+1], "/", 2)[0]] = lineNumber
+				}
+			}
+		}
+	}
+
+	if model != nil {
+		sections = append(sections, modelLintSections(model)...)
+	}
+
+	// frontends and backends may share a name, but a listen section is both
+	// and other sections only conflict with their own kind. The sections of
+	// the model follow those of the header.
+	declared := map[string][]*lintSection{}
+	for _, section := range sections {
+		if len(section.name) == 0 || section.keyword == SectionGlobal {
+			continue
+		}
+		for _, other := range declared[section.name] {
+			conflict := other.keyword == section.keyword ||
+				(section.frontend() && other.frontend()) ||
+				(section.backend() && other.backend())
+			switch {
+			case !conflict || (section.generated && other.generated):
+				continue
+			case section.generated:
+				addProblem(other.line, SeverityError, "%s %s has the same name as a %s of the dynamic configuration", other.keyword, other.name, section.keyword)
+			default:
+				addProblem(section.line, SeverityError, "%s %s has the same name as the %s at line %d", section.keyword, section.name, other.keyword, other.line)
+			}
+			break
+		}
+		declared[section.name] = append(declared[section.name], section)
+	}
+
+	routed := map[string]bool{}
+	for _, content := range maps {
+		for _, line := range strings.Split(content, "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 && !strings.HasPrefix(fields[0], "#") {
+				routed[fields[1]] = true
+			}
+		}
+	}
+	isBackend := func(name string) bool {
+		for _, section := range declared[name] {
+			if section.backend() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, section := range sections {
+		backends := []string{}
+		for backend := range section.backends {
+			backends = append(backends, backend)
+		}
+		sort.Strings(backends)
+		for _, backend := range backends {
+			routed[backend] = true
+			if !isBackend(backend) {
+				addProblem(section.backends[backend], SeverityError, "%s %s routes to unknown backend %s", section.keyword, section.name, backend)
+			}
+		}
+	}
+
+	bound := []*lintSection{}
+	for _, section := range sections {
+		if section.keyword == SectionBackEnd && !section.generated {
+			if len(section.servers) == 0 {
+				addProblem(section.line, SeverityWarning, "backend %s has no servers", section.name)
+			}
+			if !routed[section.name] {
+				addProblem(section.line, SeverityWarning, "backend %s is not routed to", section.name)
+			}
+		}
+		for idx := range section.binds {
+			bind := &section.binds[idx]
+			for _, other := range bound {
+				if section.generated && other.generated {
+					continue
+				}
+				for otherIdx := range other.binds {
+					otherBind := &other.binds[otherIdx]
+					switch {
+					case !bind.overlaps(otherBind):
+					case section.generated:
+						addProblem(otherBind.line, SeverityError, "%s %s binds to %s which is also bound by %s %s of the dynamic configuration",
+							other.keyword, other.name, otherBind.address, section.keyword, section.name)
+					default:
+						addProblem(bind.line, SeverityError, "%s %s binds to %s which is already bound by %s %s at line %d",
+							section.keyword, section.name, bind.address, other.keyword, other.name, otherBind.line)
+					}
+				}
+			}
+		}
+		bound = append(bound, section)
+	}
+
+	sort.SliceStable(problems, func(a, b int) bool {
+		return problems[a].Line < problems[b].Line
+	})
+	return problems
+}
+
+// modelLintSections returns the proxies of the model with their binds, to be
+// checked against the sections of the header.
+func modelLintSections(model *Config) []*lintSection {
+	sections := []*lintSection{}
+	addSection := func(keyword, name string, binds []Bind) {
+		section := &lintSection{keyword: keyword, name: name, generated: true}
+		for _, bind := range binds {
+			address := net.JoinHostPort(bind.Address, strconv.FormatInt(bind.Port, 10))
+			if parsed, err := parseLintBind(address, bind.Options); err == nil && parsed != nil {
+				section.binds = append(section.binds, *parsed)
+			}
+		}
+		sections = append(sections, section)
+	}
+	for _, frontend := range model.Frontends {
+		addSection(SectionFrontEnd, frontend.Name, frontend.Binds)
+	}
+	for _, backend := range model.Backends {
+		addSection(SectionBackEnd, backend.Name, nil)
+	}
+	for _, listen := range model.Listens {
+		addSection(SectionListen, listen.Name, listen.Binds)
+	}
+	return sections
+}
+
+// parseLintBind parses an address of a bind line. Sockets which aren't
+// bound to a port, such as unix sockets, are ignored.
+func parseLintBind(address string, options []string) (*lintBind, error) {
+	written := address
+	if idx := strings.Index(address, "@"); idx >= 0 {
+		switch address[:idx] {
+		case "ipv4", "ipv6", "tcp", "tcp4", "tcp6", "quic4", "quic6", "udp", "udp4", "udp6":
+			address = address[idx+1:]
+		default:
+			return nil, nil
+		}
+	}
+	if strings.HasPrefix(address, "/") {
+		return nil, nil
+	}
+	idx := strings.LastIndex(address, ":")
+	if idx < 0 {
+		return nil, fmt.Errorf("binds to %s without a port", address)
+	}
+	host, ports := strings.TrimSuffix(strings.TrimPrefix(address[:idx], "["), "]"), address[idx+1:]
+	if len(host) == 0 || host == "*" {
+		host = "0.0.0.0"
+	}
+	bind := &lintBind{address: written, host: host}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		bind.addr, bind.host = addr, addr.String()
+	}
+
+	low, high, found := strings.Cut(ports, "-")
+	var err error
+	if bind.low, err = strconv.ParseInt(low, 10, 64); err != nil || bind.low < 1 || bind.low > 65535 {
+		return nil, fmt.Errorf("binds to %s with an invalid port", address)
+	}
+	bind.high = bind.low
+	if found {
+		if bind.high, err = strconv.ParseInt(high, 10, 64); err != nil || bind.high < bind.low || bind.high > 65535 {
+			return nil, fmt.Errorf("binds to %s with an invalid port range", address)
+		}
+	}
+	for _, option := range options {
+		if option == "v6only" {
+			bind.v6only = true
+		}
+	}
+	return bind, nil
+}
+
+// serverTemplateNames returns the names of the servers of a server-template
+// line, whose range is either a count or the first and last number.
+func serverTemplateNames(prefix string, fields []string) ([]string, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("has no range")
+	}
+	template := ServerTemplate{Prefix: prefix, First: 1}
+	first, last, found := strings.Cut(fields[0], "-")
+	var err error
+	if !found {
+		template.Last, err = strconv.Atoi(first)
+	} else if template.First, err = strconv.Atoi(first); err == nil {
+		template.Last, err = strconv.Atoi(last)
+	}
+	if err != nil || template.First < 1 || template.Last < template.First {
+		return nil, fmt.Errorf("has invalid range %s", fields[0])
+	}
+	return template.Names(), nil
+}
+
+// lintFields splits a line into words the way HAProxy does, keeping quoted
+// strings and escaped spaces within a word and dropping comments.
+func lintFields(line string) []string {
+	fields := []string{}
+	word := strings.Builder{}
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, char := range line {
+		switch {
+		case escaped:
+			word.WriteRune(char)
+			escaped = false
+		case char == '\\':
+			escaped, inWord = true, true
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else {
+				word.WriteRune(char)
+			}
+		case char == '"' || char == '\'':
+			quote, inWord = char, true
+		case char == ' ' || char == '\t' || char == '\r':
+			if inWord {
+				fields = append(fields, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case char == '#' && !inWord:
+			return fields
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+	if inWord {
+		fields = append(fields, word.String())
+	}
+	return fields
+}
@@ -0,0 +1,155 @@
+package data
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode is the proxy mode of a section.
+type Mode string
+
+const (
+	ModeTCP  Mode = "tcp"
+	ModeHTTP Mode = "http"
+)
+
+// Bind is an address and port a frontend or listen section accepts
+// connections on.
+type Bind struct {
+	// Address is the address to bind to, such as 0.0.0.0 or ::
+	Address string
+	Port    int64
+	// Options follow the address, such as v6only
+	Options []string
+}
+
+func (b *Bind) String() string {
+	return joinLine("bind", net.JoinHostPort(b.Address, strconv.FormatInt(b.Port, 10)), b.Options...)
+}
+
+// Condition is the condition of a rule. Expression is either the names of
+// ACLs or an anonymous ACL in braces.
+type Condition struct {
+	// Unless negates the condition
+	Unless     bool
+	Expression string
+}
+
+func (c *Condition) String() string {
+	if c.Unless {
+		return "unless " + c.Expression
+	}
+	return "if " + c.Expression
+}
+
+// ACL is a named access control list.
+type ACL struct {
+	Name      string
+	Criterion string
+	Values    []string
+}
+
+func (a *ACL) String() string {
+	return joinLine("acl", a.Name, append([]string{a.Criterion}, a.Values...)...)
+}
+
+// TCPRequestRule is a tcp-request content rule.
+type TCPRequestRule struct {
+	// Action is what is done with a matching connection, such as accept
+	Action    string
+	Condition *Condition
+}
+
+func (r *TCPRequestRule) String() string {
+	return withCondition("tcp-request content "+r.Action, r.Condition)
+}
+
+// UseBackend is a backend switching rule. Backend is either the name of a
+// backend or a log format expression, starting with %[, which evaluates to
+// one.
+type UseBackend struct {
+	Backend   string
+	Condition *Condition
+}
+
+// Dynamic reports whether the backend is chosen by an expression.
+func (u *UseBackend) Dynamic() bool {
+	return strings.HasPrefix(u.Backend, "%[")
+}
+
+func (u *UseBackend) String() string {
+	return withCondition("use_backend "+u.Backend, u.Condition)
+}
+
+// Server is a server of a backend or listen section.
+type Server struct {
+	Name    string
+	Address string
+	Port    int64
+	// Check enables health checks of the server
+	Check bool
+	// Options follow the check option, such as verify none
+	Options []string
+}
+
+func (s *Server) String() string {
+	options := []string{net.JoinHostPort(s.Address, strconv.FormatInt(s.Port, 10))}
+	if s.Check {
+		options = append(options, "check")
+	}
+	options = append(options, s.Options...)
+	return joinLine("server", s.Name, options...)
+}
+
+// ServerTemplate is a range of servers named by a prefix and their number,
+// such as slot1 to slot8, which all start with the same address.
+type ServerTemplate struct {
+	Prefix string
+	// First and Last are the numbers of the first and last server
+	First   int
+	Last    int
+	Address string
+	Port    int64
+	Check   bool
+	Options []string
+}
+
+// Names returns the names of the servers of the template.
+func (t *ServerTemplate) Names() []string {
+	names := []string{}
+	for number := t.First; number <= t.Last; number++ {
+		names = append(names, t.Prefix+strconv.Itoa(number))
+	}
+	return names
+}
+
+func (t *ServerTemplate) String() string {
+	options := []string{
+		fmt.Sprintf("%d-%d", t.First, t.Last),
+		net.JoinHostPort(t.Address, strconv.FormatInt(t.Port, 10)),
+	}
+	if t.Check {
+		options = append(options, "check")
+	}
+	options = append(options, t.Options...)
+	return joinLine("server-template", t.Prefix, options...)
+}
+
+// milliseconds formats a duration in the milliseconds HAProxy defaults to.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(d.Milliseconds(), 10)
+}
+
+func joinLine(keyword, value string, options ...string) string {
+	return strings.Join(append([]string{keyword, value}, options...), " ")
+}
+
+func withCondition(line string, condition *Condition) string {
+	if condition == nil {
+		return line
+	}
+	return fmt.Sprintf("%s %s", line, condition)
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+const (
+	SectionGlobal   = "global"
+	SectionDefaults = "defaults"
+	SectionFrontEnd = "frontend"
+	SectionBackEnd  = "backend"
+	SectionListen   = "listen"
+)
+
+// Global is the global section. Its directives are kept as they are written.
+type Global struct {
+	Directives []string
+}
+
+// Serialize writes the section to buf, allocating a buffer if buf is nil.
+func (g *Global) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	return serializeSection(buf, SectionGlobal, "", g.Directives)
+}
+
+// Timeout is a timeout directive, such as connect or client.
+type Timeout struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Defaults is a defaults section, which may be named.
+type Defaults struct {
+	Name       string
+	Mode       Mode
+	Timeouts   []Timeout
+	Directives []string
+}
+
+// Serialize writes the section to buf, allocating a buffer if buf is nil.
+func (d *Defaults) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	lines := modeLines(d.Mode)
+	for _, timeout := range d.Timeouts {
+		lines = append(lines, fmt.Sprintf("timeout %s %s", timeout.Name, milliseconds(timeout.Duration)))
+	}
+	lines = append(lines, d.Directives...)
+	return serializeSection(buf, SectionDefaults, d.Name, lines)
+}
+
+// Frontend accepts connections and routes them to backends.
+type Frontend struct {
+	Name  string
+	Mode  Mode
+	Binds []Bind
+	ACLs  []ACL
+	// TCPRequestRules are applied to connections until InspectDelay ends
+	TCPRequestRules []TCPRequestRule
+	// InspectDelay is how long the content of a connection is waited for
+	InspectDelay   time.Duration
+	UseBackends    []UseBackend
+	DefaultBackend string
+	Directives     []string
+}
+
+// AddBind adds an address the frontend accepts connections on.
+func (f *Frontend) AddBind(bind Bind) {
+	f.Binds = append(f.Binds, bind)
+}
+
+// AddUseBackend adds a backend switching rule after those already added.
+func (f *Frontend) AddUseBackend(useBackend UseBackend) {
+	f.UseBackends = append(f.UseBackends, useBackend)
+}
+
+// Serialize writes the section to buf, allocating a buffer if buf is nil.
+func (f *Frontend) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	lines := modeLines(f.Mode)
+	for idx := range f.Binds {
+		lines = append(lines, f.Binds[idx].String())
+	}
+	for idx := range f.ACLs {
+		lines = append(lines, f.ACLs[idx].String())
+	}
+	for idx := range f.TCPRequestRules {
+		lines = append(lines, f.TCPRequestRules[idx].String())
+	}
+	if f.InspectDelay > 0 {
+		lines = append(lines, "tcp-request inspect-delay "+milliseconds(f.InspectDelay))
+	}
+	for idx := range f.UseBackends {
+		lines = append(lines, f.UseBackends[idx].String())
+	}
+	if len(f.DefaultBackend) > 0 {
+		lines = append(lines, "default_backend "+f.DefaultBackend)
+	}
+	lines = append(lines, f.Directives...)
+	return serializeSection(buf, SectionFrontEnd, f.Name, lines)
+}
+
+// Backend is a set of servers connections are routed to.
+type Backend struct {
+	Name string
+	Mode Mode
+	// Balance is the load balancing algorithm, such as roundrobin
+	Balance    string
+	Directives []string
+	Servers    []Server
+	// ServerTemplates follow the servers
+	ServerTemplates []ServerTemplate
+}
+
+// AddServer adds a server to the backend.
+func (b *Backend) AddServer(server Server) {
+	b.Servers = append(b.Servers, server)
+}
+
+// Serialize writes the section to buf, allocating a buffer if buf is nil.
+func (b *Backend) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	lines := modeLines(b.Mode)
+	if len(b.Balance) > 0 {
+		lines = append(lines, "balance "+b.Balance)
+	}
+	lines = append(lines, b.Directives...)
+	for idx := range b.Servers {
+		lines = append(lines, b.Servers[idx].String())
+	}
+	for idx := range b.ServerTemplates {
+		lines = append(lines, b.ServerTemplates[idx].String())
+	}
+	return serializeSection(buf, SectionBackEnd, b.Name, lines)
+}
+
+// Listen is a frontend and backend in one section.
+type Listen struct {
+	Name       string
+	Mode       Mode
+	Binds      []Bind
+	Balance    string
+	Directives []string
+	Servers    []Server
+}
+
+// Serialize writes the section to buf, allocating a buffer if buf is nil.
+func (l *Listen) Serialize(buf *bytes.Buffer) *bytes.Buffer {
+	lines := modeLines(l.Mode)
+	for idx := range l.Binds {
+		lines = append(lines, l.Binds[idx].String())
+	}
+	if len(l.Balance) > 0 {
+		lines = append(lines, "balance "+l.Balance)
+	}
+	lines = append(lines, l.Directives...)
+	for idx := range l.Servers {
+		lines = append(lines, l.Servers[idx].String())
+	}
+	return serializeSection(buf, SectionListen, l.Name, lines)
+}
+
+func modeLines(mode Mode) []string {
+	if len(mode) == 0 {
+		return []string{}
+	}
+	return []string{"mode " + string(mode)}
+}
+
+func serializeSection(buf *bytes.Buffer, sectionType, name string, lines []string) *bytes.Buffer {
+	if buf == nil {
+		buf = &bytes.Buffer{}
+	}
+	if len(name) > 0 {
+		fmt.Fprintf(buf, "\n%s %s\n", sectionType, name)
+	} else {
+		fmt.Fprintf(buf, "\n%s\n", sectionType)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(buf, "  %s\n", line)
+	}
+	return buf
+}
@@ -1,8 +1,12 @@
 package data
 
+import "time"
+
 type NamespaceTarget struct {
 	Namespace  string
 	JobHash    string
 	APIVIP     []string
 	IngressVIP []string
+	// FirstSeen is when the earliest pod of the job was created
+	FirstSeen time.Time
 }
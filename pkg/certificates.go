@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/x509"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultCertificateExpiryWarningDays is how long before a certificate expires
+// a warning is raised unless the config sets otherwise.
+const defaultCertificateExpiryWarningDays = 14
+
+// certificateExpiryWarning returns how long before a certificate expires a
+// warning is raised.
+func certificateExpiryWarning(days int) time.Duration {
+	if days == 0 {
+		days = defaultCertificateExpiryWarningDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// chainSelfSigned reports whether the last certificate of a served chain is
+// signed by itself, so the chain is anchored by a certificate supplied by the
+// server rather than by a CA the client already trusts.
+func chainSelfSigned(certs []*x509.Certificate) bool {
+	if len(certs) == 0 {
+		return false
+	}
+	last := certs[len(certs)-1]
+	if !bytes.Equal(last.RawIssuer, last.RawSubject) {
+		return false
+	}
+	return last.CheckSignatureFrom(last) == nil
+}
+
+type certificateKey struct {
+	baseDomain string
+	port       int64
+}
+
+// updateCertificateMetrics publishes the expiry, issuer and trust of the
+// certificates served by the routed addresses of each cluster. A warning is
+// raised the first time a certificate is seen within the expiry warning.
+func (i *Inventory) updateCertificateMetrics(now time.Time, expiryWarning time.Duration) {
+	certificateExpiryDays.Reset()
+	certificateInfo.Reset()
+	certificateSelfSigned.Reset()
+
+	expiries := map[certificateKey]time.Time{}
+	selfSigned := map[certificateKey]bool{}
+	for _, entry := range i.entries {
+		if !entry.Routed || len(entry.BaseDomain) == 0 || entry.NotAfter.IsZero() {
+			continue
+		}
+		key := certificateKey{baseDomain: entry.BaseDomain, port: entry.Port}
+		if expiry, exists := expiries[key]; !exists || entry.NotAfter.Before(expiry) {
+			expiries[key] = entry.NotAfter
+		}
+		port := portLabel(entry.Port)
+		certificateInfo.WithLabelValues(entry.BaseDomain, port, entry.Issuer).Set(1)
+		selfSigned[key] = selfSigned[key] || entry.SelfSigned
+
+		if entry.NotAfter.Sub(now) < expiryWarning && !i.expiryWarned[entry.Fingerprint] {
+			i.expiryWarned[entry.Fingerprint] = true
+			recordEvent(corev1.EventTypeWarning, EventReasonCertificateExpiring,
+				"certificate of %s served on %s:%d expires at %s", entry.BaseDomain, entry.Address, entry.Port,
+				entry.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	for key, expiry := range expiries {
+		certificateExpiryDays.WithLabelValues(key.baseDomain, portLabel(key.port)).Set(expiry.Sub(now).Hours() / 24)
+		value := 0.0
+		if selfSigned[key] {
+			value = 1
+		}
+		certificateSelfSigned.WithLabelValues(key.baseDomain, portLabel(key.port)).Set(value)
+	}
+}
@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type fakeEventRecorder struct {
+	reasons []string
+}
+
+func (r *fakeEventRecorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	r.reasons = append(r.reasons, reason)
+}
+
+func createCertificate(t *testing.T, subject string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: subject},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestChainSelfSigned(t *testing.T) {
+	root, rootKey := createCertificate(t, "root", nil, nil)
+	leaf, _ := createCertificate(t, "api.cluster.example.com", root, rootKey)
+	other, otherKey := createCertificate(t, "root", nil, nil)
+	forged, _ := createCertificate(t, "root", other, otherKey)
+
+	tests := []struct {
+		name     string
+		certs    []*x509.Certificate
+		expected bool
+	}{
+		{name: "no certificates", expected: false},
+		{name: "leaf only", certs: []*x509.Certificate{leaf}, expected: false},
+		{name: "chain with root", certs: []*x509.Certificate{leaf, root}, expected: true},
+		{name: "self-signed leaf", certs: []*x509.Certificate{root}, expected: true},
+		{name: "subject matching issuer signed by another key", certs: []*x509.Certificate{leaf, forged}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if selfSigned := chainSelfSigned(tt.certs); selfSigned != tt.expected {
+				t.Fatalf("expected %v, got %v", tt.expected, selfSigned)
+			}
+		})
+	}
+}
+
+func gaugeValue(t *testing.T, gauge interface{ Write(*dto.Metric) error }) float64 {
+	var metric dto.Metric
+	if err := gauge.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetGauge().GetValue()
+}
+
+func TestCertificateMetrics(t *testing.T) {
+	recorder := &fakeEventRecorder{}
+	SetEventRecorder(recorder)
+	defer SetEventRecorder(nil)
+
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	monitorPort := &data.MonitorPort{Port: 6443}
+	inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, &PortStatus{
+		BaseDomain:  "cluster.example.com",
+		Fingerprint: "aaa",
+		Issuer:      "CN=kube-apiserver-lb-signer",
+		NotAfter:    now.Add(30 * 24 * time.Hour),
+	}, nil, now)
+	inventory.Record("192.168.1.0/24", "192.168.1.11", monitorPort, &PortStatus{
+		BaseDomain:  "cluster.example.com",
+		Fingerprint: "bbb",
+		Issuer:      "CN=kube-apiserver-lb-signer",
+		NotAfter:    now.Add(10 * 24 * time.Hour),
+		SelfSigned:  true,
+	}, nil, now)
+
+	warning := certificateExpiryWarning(0)
+	inventory.updateMetrics(now, warning)
+	if days := gaugeValue(t, certificateExpiryDays.WithLabelValues("cluster.example.com", "6443")); days != 10 {
+		t.Fatalf("expected the first certificate to expire in 10 days, got %v", days)
+	}
+	if info := gaugeValue(t, certificateInfo.WithLabelValues("cluster.example.com", "6443", "CN=kube-apiserver-lb-signer")); info != 1 {
+		t.Fatalf("expected the issuer to be exported, got %v", info)
+	}
+	if selfSigned := gaugeValue(t, certificateSelfSigned.WithLabelValues("cluster.example.com", "6443")); selfSigned != 1 {
+		t.Fatalf("expected the chain to be self-signed, got %v", selfSigned)
+	}
+
+	// each certificate is only warned about once
+	inventory.updateMetrics(now.Add(time.Hour), warning)
+	if len(recorder.reasons) != 1 || recorder.reasons[0] != EventReasonCertificateExpiring {
+		t.Fatalf("expected a single certificate expiring event, got %v", recorder.reasons)
+	}
+}
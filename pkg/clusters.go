@@ -0,0 +1,292 @@
+package pkg
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/sirupsen/logrus"
+)
+
+// Resolutions of a base domain claimed on the same port by more than one
+// source.
+const (
+	// ConflictPinFirst keeps routing the source which claimed the domain first
+	ConflictPinFirst = "pin-first"
+	// ConflictNewestWins routes the source which claimed the domain last
+	ConflictNewestWins = "newest-wins"
+	// ConflictRefuse routes none of the sources
+	ConflictRefuse = "refuse"
+)
+
+// DomainConflict is a base domain claimed on the same port by more than one
+// source.
+type DomainConflict struct {
+	BaseDomain string
+	Port       int64
+	// Sources name the claims on the port, each the ranges, or pod controller
+	// jobs, which serve the same cluster and the issuer of its certificate
+	Sources []string
+	// Resolution is how the conflict was resolved
+	Resolution string
+	// Winner is the source which is routed, or empty if none is
+	Winner string
+}
+
+// domainClaim is the targets one or more sources claim for a port of a base
+// domain. Sources whose targets serve certificates from the same issuer are
+// the same cluster and share a claim.
+type domainClaim struct {
+	baseDomain string
+	sources    []string
+	issuer     string
+	firstSeen  time.Time
+	port       data.MonitorPort
+	targets    []string
+}
+
+// name identifies a claim in conflicts.
+func (c *domainClaim) name() string {
+	name := strings.Join(c.sources, "+")
+	if len(c.issuer) > 0 {
+		name += " (" + c.issuer + ")"
+	}
+	return name
+}
+
+// claimedBefore orders claims by when they were first seen. Claims whose
+// first seen time is unknown are ordered last, as they were seen most
+// recently.
+func claimedBefore(a, b *domainClaim) bool {
+	if a.firstSeen.IsZero() != b.firstSeen.IsZero() {
+		return !a.firstSeen.IsZero()
+	}
+	return a.firstSeen.Before(b.firstSeen)
+}
+
+// rangeSource identifies what produced a range in conflicts.
+func rangeSource(monitorRange *data.MonitorRange) string {
+	if len(monitorRange.Source) > 0 {
+		return monitorRange.Source
+	}
+	return RangeKey(monitorRange)
+}
+
+// DiscoveredClusters groups the targets of scanned ranges by the base domain
+// found at each address, returning a range for each cluster whose ports only
+// hold that cluster's addresses. A range can hold any number of clusters and
+// a cluster can span ranges. Addresses where no base domain was found are
+// dropped. Ranges which already have a base domain are clusters in their own
+// right and are kept as they are.
+//
+// Targets of a port of a base domain are only merged if they serve
+// certificates from the same issuer. A port claimed by targets which can't be
+// told to be the same cluster, such as those of two pod controller jobs or of
+// scanned ranges serving certificates from different issuers, is a conflict
+// which is settled by the resolution, pin-first if it is empty, and returned.
+func DiscoveredClusters(monitorRanges []data.MonitorRange, resolution string) ([]data.MonitorRange, []DomainConflict) {
+	claims := []*domainClaim{}
+	claimMap := map[string]*domainClaim{}
+
+	addClaim := func(source, baseDomain string, monitorPort data.MonitorPort, issuer string, targets []string, firstSeen time.Time) {
+		identity := "source/" + source
+		if len(issuer) > 0 {
+			identity = "issuer/" + issuer
+		}
+		key := strings.Join([]string{baseDomain, portLabel(monitorPort.Port), identity}, "/")
+		claim, exists := claimMap[key]
+		if !exists {
+			monitorPort.Targets = nil
+			monitorPort.TargetIssuers = nil
+			claim = &domainClaim{
+				baseDomain: baseDomain,
+				issuer:     issuer,
+				port:       monitorPort,
+			}
+			claimMap[key] = claim
+			claims = append(claims, claim)
+		}
+		hasSource := false
+		for _, claimSource := range claim.sources {
+			hasSource = hasSource || claimSource == source
+		}
+		if !hasSource {
+			claim.sources = append(claim.sources, source)
+		}
+		if !firstSeen.IsZero() && (claim.firstSeen.IsZero() || firstSeen.Before(claim.firstSeen)) {
+			claim.firstSeen = firstSeen
+		}
+		claim.targets = append(claim.targets, targets...)
+	}
+
+	for idx := range monitorRanges {
+		monitorRange := &monitorRanges[idx]
+		source := rangeSource(monitorRange)
+		if len(monitorRange.BaseDomain) > 0 {
+			for _, monitorPort := range monitorRange.MonitorPorts {
+				addClaim(source, monitorRange.BaseDomain, monitorPort, "", monitorPort.Targets, monitorRange.FirstSeen)
+			}
+			continue
+		}
+
+		// an address is part of a cluster if any of its ports served a
+		// certificate of the cluster
+		baseDomains := []string{}
+		seen := map[string]bool{}
+		for _, baseDomain := range monitorRange.TargetDomains {
+			if !seen[baseDomain] {
+				seen[baseDomain] = true
+				baseDomains = append(baseDomains, baseDomain)
+			}
+		}
+		sort.Strings(baseDomains)
+
+		for _, baseDomain := range baseDomains {
+			for _, monitorPort := range monitorRange.MonitorPorts {
+				issuers := []string{}
+				targets := map[string][]string{}
+				firstSeen := map[string]time.Time{}
+				for _, target := range monitorPort.Targets {
+					if monitorRange.TargetDomains[target] != baseDomain {
+						continue
+					}
+					issuer := monitorPort.TargetIssuers[target]
+					if _, exists := targets[issuer]; !exists {
+						issuers = append(issuers, issuer)
+					}
+					targets[issuer] = append(targets[issuer], target)
+					targetSeen := monitorRange.TargetFirstSeen[target]
+					if !targetSeen.IsZero() && (firstSeen[issuer].IsZero() || targetSeen.Before(firstSeen[issuer])) {
+						firstSeen[issuer] = targetSeen
+					}
+				}
+				for _, issuer := range issuers {
+					addClaim(source, baseDomain, monitorPort, issuer, targets[issuer], firstSeen[issuer])
+				}
+			}
+		}
+	}
+
+	conflicts, refused := resolveConflicts(claims, resolution)
+
+	clusters := []*data.MonitorRange{}
+	clusterMap := map[string]*data.MonitorRange{}
+	for _, claim := range claims {
+		if refused[claim] {
+			continue
+		}
+		cluster, exists := clusterMap[claim.baseDomain]
+		if !exists {
+			cluster = &data.MonitorRange{
+				BaseDomain:   claim.baseDomain,
+				MonitorPorts: []data.MonitorPort{},
+			}
+			clusterMap[claim.baseDomain] = cluster
+			clusters = append(clusters, cluster)
+		}
+		monitorPort := claim.port
+		monitorPort.Targets = append([]string{}, claim.targets...)
+		cluster.MonitorPorts = append(cluster.MonitorPorts, monitorPort)
+	}
+
+	discovered := []data.MonitorRange{}
+	for _, cluster := range clusters {
+		discovered = append(discovered, *cluster)
+	}
+	return discovered, conflicts
+}
+
+// resolveConflicts finds the ports of base domains with more than one claim
+// and settles them by the resolution, returning the conflicts and the claims
+// which are not routed.
+func resolveConflicts(claims []*domainClaim, resolution string) ([]DomainConflict, map[*domainClaim]bool) {
+	if len(resolution) == 0 {
+		resolution = ConflictPinFirst
+	}
+
+	byPort := map[string][]*domainClaim{}
+	keys := []string{}
+	for _, claim := range claims {
+		key := claim.baseDomain + "/" + portLabel(claim.port.Port)
+		if _, exists := byPort[key]; !exists {
+			keys = append(keys, key)
+		}
+		byPort[key] = append(byPort[key], claim)
+	}
+
+	conflicts := []DomainConflict{}
+	refused := map[*domainClaim]bool{}
+	for _, key := range keys {
+		portClaims := byPort[key]
+		if len(portClaims) < 2 {
+			continue
+		}
+
+		conflict := DomainConflict{
+			BaseDomain: portClaims[0].baseDomain,
+			Port:       portClaims[0].port.Port,
+			Resolution: resolution,
+		}
+		for _, claim := range portClaims {
+			conflict.Sources = append(conflict.Sources, claim.name())
+		}
+
+		ordered := append([]*domainClaim{}, portClaims...)
+		sort.SliceStable(ordered, func(a, b int) bool {
+			return claimedBefore(ordered[a], ordered[b])
+		})
+		var winner *domainClaim
+		switch resolution {
+		case ConflictPinFirst:
+			winner = ordered[0]
+		case ConflictNewestWins:
+			winner = ordered[len(ordered)-1]
+		}
+		if winner != nil {
+			conflict.Winner = winner.name()
+		}
+		for _, claim := range portClaims {
+			if claim != winner {
+				refused[claim] = true
+			}
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, refused
+}
+
+var (
+	reportedConflicts     = map[string]bool{}
+	reportedConflictsLock sync.Mutex
+)
+
+// ReportConflicts publishes the conflicts found by DiscoveredClusters as
+// metrics and logs each conflict the first time it is found. It is called
+// once for each reconcile or render rather than by DiscoveredClusters, which
+// is run more than once for each.
+func ReportConflicts(conflicts []DomainConflict) {
+	reportedConflictsLock.Lock()
+	defer reportedConflictsLock.Unlock()
+
+	domainConflicts.Reset()
+	current := map[string]bool{}
+	for _, conflict := range conflicts {
+		domainConflicts.WithLabelValues(conflict.BaseDomain, portLabel(conflict.Port)).Set(float64(len(conflict.Sources)))
+
+		key := strings.Join(append([]string{conflict.BaseDomain, portLabel(conflict.Port), conflict.Resolution}, conflict.Sources...), "/")
+		current[key] = true
+		if reportedConflicts[key] {
+			continue
+		}
+		if len(conflict.Winner) > 0 {
+			logrus.Warnf("base domain %s on port %d is claimed by %s, routing %s (%s)",
+				conflict.BaseDomain, conflict.Port, strings.Join(conflict.Sources, ", "), conflict.Winner, conflict.Resolution)
+		} else {
+			logrus.Warnf("base domain %s on port %d is claimed by %s, routing none of them (%s)",
+				conflict.BaseDomain, conflict.Port, strings.Join(conflict.Sources, ", "), conflict.Resolution)
+		}
+	}
+	reportedConflicts = current
+}
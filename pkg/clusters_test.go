@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestDiscoveredClusters(t *testing.T) {
+	monitorRanges := []data.MonitorRange{
+		{
+			IpCidr: "192.168.1.0/28",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.2", "192.168.1.6", "192.168.1.9"},
+					TargetIssuers: map[string]string{"192.168.1.6": "CN=cluster-a-signer"}},
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.3", "192.168.1.7"}},
+			},
+			TargetDomains: map[string]string{
+				"192.168.1.2": "cluster-b.example.com",
+				"192.168.1.3": "cluster-b.example.com",
+				"192.168.1.6": "cluster-a.example.com",
+				"192.168.1.7": "cluster-a.example.com",
+			},
+		},
+		{
+			IpCidr: "192.168.2.0/28",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{"192.168.2.2"},
+					TargetIssuers: map[string]string{"192.168.2.2": "CN=cluster-a-signer"}},
+			},
+			TargetDomains: map[string]string{
+				"192.168.2.2": "cluster-a.example.com",
+			},
+		},
+		{
+			BaseDomain: "cluster-c.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api.", Targets: []string{"10.0.0.2"}},
+			},
+		},
+	}
+
+	expected := []data.MonitorRange{
+		{
+			BaseDomain: "cluster-a.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.6", "192.168.2.2"}},
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.7"}},
+			},
+		},
+		{
+			BaseDomain: "cluster-b.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.2"}},
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.3"}},
+			},
+		},
+		{
+			BaseDomain: "cluster-c.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api.", Targets: []string{"10.0.0.2"}},
+			},
+		},
+	}
+
+	// the targets of cluster-a in both ranges serve certificates from the
+	// same issuer so they are merged rather than in conflict
+	clusters, conflicts := DiscoveredClusters(monitorRanges, "")
+	if !reflect.DeepEqual(clusters, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, clusters)
+	}
+	if len(conflicts) > 0 {
+		t.Fatalf("expected no conflicts, got %+v", conflicts)
+	}
+
+	config, err := BuildDynamicConfiguration(&data.MonitorConfig{MonitorRanges: monitorRanges})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range []string{
+		"backend cluster-a.example.com-6443",
+		"backend cluster-a.example.com-443",
+		"backend cluster-b.example.com-6443",
+		"backend cluster-b.example.com-443",
+		"backend cluster-c.example.com-6443",
+	} {
+		if !strings.Contains(config, backend+"\n") {
+			t.Fatalf("expected %s in:\n%s", backend, config)
+		}
+	}
+	if strings.Contains(config, "192.168.1.9") {
+		t.Fatalf("expected the address without a base domain to be dropped:\n%s", config)
+	}
+}
+
+func TestDiscoveredClustersConflicts(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	monitorRanges := []data.MonitorRange{
+		{
+			IpCidr: "192.168.1.0/28",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, Targets: []string{"192.168.1.2"}, TargetIssuers: map[string]string{"192.168.1.2": "CN=signer-new"}},
+			},
+			TargetDomains:   map[string]string{"192.168.1.2": "cluster.example.com"},
+			TargetFirstSeen: map[string]time.Time{"192.168.1.2": start.Add(time.Hour)},
+		},
+		{
+			IpCidr: "10.0.0.0/28",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, Targets: []string{"10.0.0.2"}, TargetIssuers: map[string]string{"10.0.0.2": "CN=signer-old"}},
+				{Port: 443, Targets: []string{"10.0.0.3"}, TargetIssuers: map[string]string{"10.0.0.3": "CN=signer-old"}},
+			},
+			TargetDomains:   map[string]string{"10.0.0.2": "cluster.example.com", "10.0.0.3": "cluster.example.com"},
+			TargetFirstSeen: map[string]time.Time{"10.0.0.2": start, "10.0.0.3": start},
+		},
+	}
+
+	tests := []struct {
+		resolution string
+		winner     string
+		targets    []string
+	}{
+		{resolution: "", winner: "10.0.0.0/28 (CN=signer-old)", targets: []string{"10.0.0.2"}},
+		{resolution: ConflictPinFirst, winner: "10.0.0.0/28 (CN=signer-old)", targets: []string{"10.0.0.2"}},
+		{resolution: ConflictNewestWins, winner: "192.168.1.0/28 (CN=signer-new)", targets: []string{"192.168.1.2"}},
+		{resolution: ConflictRefuse, winner: "", targets: nil},
+	}
+	for _, tt := range tests {
+		t.Run("resolution "+tt.resolution, func(t *testing.T) {
+			clusters, conflicts := DiscoveredClusters(monitorRanges, tt.resolution)
+			if len(conflicts) != 1 {
+				t.Fatalf("expected a single conflict, got %+v", conflicts)
+			}
+			conflict := conflicts[0]
+			if conflict.BaseDomain != "cluster.example.com" || conflict.Port != 6443 || conflict.Winner != tt.winner ||
+				!reflect.DeepEqual(conflict.Sources, []string{"192.168.1.0/28 (CN=signer-new)", "10.0.0.0/28 (CN=signer-old)"}) {
+				t.Fatalf("unexpected conflict %+v", conflict)
+			}
+
+			// the port which is not in conflict is routed whatever the
+			// resolution
+			targets := map[int64][]string{}
+			for _, cluster := range clusters {
+				for _, monitorPort := range cluster.MonitorPorts {
+					targets[monitorPort.Port] = append(targets[monitorPort.Port], monitorPort.Targets...)
+				}
+			}
+			if !reflect.DeepEqual(targets[6443], tt.targets) || !reflect.DeepEqual(targets[443], []string{"10.0.0.3"}) {
+				t.Fatalf("unexpected targets %v", targets)
+			}
+
+			config, err := BuildDynamicConfiguration(&data.MonitorConfig{MonitorRanges: monitorRanges, ConflictResolution: tt.resolution})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := strings.Count(config, "\nbackend cluster.example.com-6443\n"); count > 1 {
+				t.Fatalf("expected at most one backend for the port in conflict:\n%s", config)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// configReloadDelay allows writes to the config file to settle before it is
+// reloaded, so a file which is truncated and then written is read only once.
+const configReloadDelay = 250 * time.Millisecond
+
+func hashConfigFile(path string) (string, error) {
+	configRaw, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return util.GenerateSHA512Hash(configRaw), nil
+}
+
+// WatchConfig watches the monitor config file until ctx is done. Each time the
+// content of the file changes it is reloaded and onChange is called with the
+// new config. If the new file is invalid the error is logged and onChange is
+// not called, leaving the current config in place.
+func WatchConfig(ctx context.Context, onChange func(*data.MonitorConfig)) error {
+	return WatchConfigFile(ctx, ConfigPath(), onChange)
+}
+
+// WatchConfigFile watches the monitor config at path, see WatchConfig.
+func WatchConfigFile(ctx context.Context, path string, onChange func(*data.MonitorConfig)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("unable to create config watcher: %v", err)
+	}
+
+	// the parent directory is watched so that files replaced by a rename, such
+	// as a mounted ConfigMap, continue to be tracked.
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("unable to watch %s: %v", path, err)
+	}
+
+	lastHash, err := hashConfigFile(path)
+	if err != nil {
+		logrus.Warnf("unable to read monitor config %s: %v", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		reload := time.NewTimer(configReloadDelay)
+		reload.Stop()
+		defer reload.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				reload.Reset(configReloadDelay)
+			case <-reload.C:
+				hash, err := hashConfigFile(path)
+				if err != nil || hash == lastHash {
+					continue
+				}
+				lastHash = hash
+
+				logrus.Infof("monitor config %s has changed, reloading", path)
+				config, err := LoadConfig(path)
+				if err != nil {
+					logrus.Errorf("unable to reload monitor config, keeping the current config: %v", err)
+					continue
+				}
+				onChange(config)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.Errorf("error while watching monitor config %s: %v", path, err)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+const (
+	watchedConfig = `monitor-config:
+  check-timeout: 100
+  base-domain: example.com
+`
+	updatedWatchedConfig = `monitor-config:
+  check-timeout: 100
+  base-domain: updated.example.com
+`
+	invalidWatchedConfig = `monitor-config: [
+`
+)
+
+func TestWatchConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "monitor-config.yaml")
+	if err := os.WriteFile(configPath, []byte(watchedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(monitorConfigEnvVar, configPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configs := make(chan *data.MonitorConfig, 10)
+	if err := WatchConfig(ctx, func(config *data.MonitorConfig) {
+		configs <- config
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(configPath, []byte(invalidWatchedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case config := <-configs:
+		t.Fatalf("invalid config should not be applied, got %+v", config)
+	case <-time.After(4 * configReloadDelay):
+	}
+
+	if err := os.WriteFile(configPath, []byte(updatedWatchedConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case config := <-configs:
+		if config.BaseDomain != "updated.example.com" {
+			t.Fatalf("expected reloaded base domain updated.example.com, got %s", config.BaseDomain)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for config reload")
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	previous := monitorConfig
+	defer func() { monitorConfig = previous }()
+
+	config := &data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{{
+			BaseDomain:      "example.com",
+			MonitorPorts:    []data.MonitorPort{{Port: 443, Targets: []string{"192.168.1.4"}, TargetIssuers: map[string]string{"192.168.1.4": "CN=signer"}}},
+			TargetDomains:   map[string]string{"192.168.1.4": "example.com"},
+			TargetFirstSeen: map[string]time.Time{"192.168.1.4": time.Now()},
+		}},
+	}
+	SetConfig(config)
+
+	// a scan of the stored config leaves the caller's config alone
+	stored := &monitorConfig.MonitorConfig.MonitorRanges[0]
+	stored.MonitorPorts[0].Targets[0] = "192.168.1.5"
+	stored.MonitorPorts[0].TargetIssuers["192.168.1.5"] = "CN=other"
+	stored.TargetDomains["192.168.1.5"] = "example.com"
+	stored.TargetFirstSeen["192.168.1.5"] = time.Now()
+	stored.MonitorPorts[0].Port = 6443
+
+	monitorRange := config.MonitorRanges[0]
+	if monitorRange.MonitorPorts[0].Port != 443 || monitorRange.MonitorPorts[0].Targets[0] != "192.168.1.4" ||
+		len(monitorRange.MonitorPorts[0].TargetIssuers) != 1 || len(monitorRange.TargetDomains) != 1 || len(monitorRange.TargetFirstSeen) != 1 {
+		t.Fatalf("expected the config to be copied, got %+v", monitorRange)
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"fmt"
 	"log"
 	"net/netip"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
 	"github.com/sirupsen/logrus"
@@ -23,8 +27,23 @@ import (
 
 var (
 	targetsMutex sync.Mutex
+	configMutex  sync.RWMutex
 )
 
+// ReconcileStatus is the outcome of the most recent reconcile.
+type ReconcileStatus struct {
+	// Time is when the reconcile ran
+	Time time.Time
+	// ConfigHash is the hash of the last published HAProxy configuration
+	ConfigHash string
+	// Clusters are the clusters routed by the HAProxy configuration
+	Clusters []data.MonitorRange
+	// Conflicts are the ports of base domains claimed by more than one job
+	Conflicts []pkg.DomainConflict
+	// Err is set if the HAProxy configuration could not be published
+	Err error
+}
+
 type ControllerContext struct {
 	namespaceTargets  map[string]map[string]*data.NamespaceTarget
 	config            *data.MonitorConfig
@@ -32,6 +51,9 @@ type ControllerContext struct {
 	namespace         string
 	log               logr.Logger
 	lastMonitorConfig *data.MonitorConfig
+	status            ReconcileStatus
+	reconcileNow      chan struct{}
+	rules             *pkg.NamespaceRules
 }
 
 func getEnvFromPod(pod *corev1.Pod, varName string) (string, error) {
@@ -47,21 +69,87 @@ func getEnvFromPod(pod *corev1.Pod, varName string) (string, error) {
 	return "", fmt.Errorf("unable to find envvar %s", varName)
 
 }
+
+// getJobIdentity returns the job identity of a pod from the source configured
+// in the namespace rules.
+func getJobIdentity(pod *corev1.Pod, jobIdentity data.JobIdentity) (string, error) {
+	var values map[string]string
+	switch jobIdentity.Source {
+	case pkg.JobIdentitySourceEnv:
+		return getEnvFromPod(pod, jobIdentity.Name)
+	case pkg.JobIdentitySourceLabel:
+		values = pod.Labels
+	case pkg.JobIdentitySourceAnnotation:
+		values = pod.Annotations
+	default:
+		return "", fmt.Errorf("unknown job identity source %s", jobIdentity.Source)
+	}
+
+	if value, exists := values[jobIdentity.Name]; exists && len(value) > 0 {
+		return value, nil
+	}
+	return "", fmt.Errorf("unable to find %s %s", jobIdentity.Source, jobIdentity.Name)
+}
+
 func (c *ControllerContext) Initialize(config *data.MonitorConfig, client client.Client, namespace string) {
 	c.namespaceTargets = map[string]map[string]*data.NamespaceTarget{}
-	c.config = config
 	c.namespace = namespace
 	c.log = zap.New()
 	c.client = client
+	c.reconcileNow = make(chan struct{}, 1)
+	if config != nil {
+		if err := c.SetConfig(config); err != nil {
+			logrus.Errorf("unable to apply monitor config: %v", err)
+		}
+	}
+}
+
+// SetConfig atomically replaces the monitor config. The next reconcile
+// rebuilds the HAProxy configuration even if the targets have not changed. If
+// the namespace rules of the config can't be compiled the current config is
+// kept.
+func (c *ControllerContext) SetConfig(config *data.MonitorConfig) error {
+	rules, err := pkg.CompileNamespaceRules(config.NamespaceRules)
+	if err != nil {
+		return fmt.Errorf("invalid namespace rules: %w", err)
+	}
+
+	configMutex.Lock()
+	c.config = config
+	c.rules = rules
+	configMutex.Unlock()
+
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	c.lastMonitorConfig = nil
+	return nil
+}
+
+func (c *ControllerContext) getConfig() *data.MonitorConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return c.config
+}
+
+func (c *ControllerContext) getRules() *pkg.NamespaceRules {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return c.rules
 }
 
-func (c *ControllerContext) Update(pod *corev1.Pod) {
+// Update tracks the job run by a pod if the pod's namespace matches the
+// namespace rules.
+func (c *ControllerContext) Update(pod *corev1.Pod, namespace *corev1.Namespace) {
+	rules := c.getRules()
+	if rules == nil {
+		return
+	}
 	ns := pod.Namespace
-	if !strings.HasPrefix(ns, "ci-ln-") {
+	if !rules.MatchNamespace(ns, namespace.Labels) {
 		return
 	}
 	var jobHash string
-	jobHash, err := getEnvFromPod(pod, "JOB_NAME_HASH")
+	jobHash, err := getJobIdentity(pod, rules.JobIdentity)
 	if err != nil {
 		log.Printf("no job hash is associated with this pod: %v", err)
 		return
@@ -76,29 +164,58 @@ func (c *ControllerContext) Update(pod *corev1.Pod) {
 		namespaceTarget = map[string]*data.NamespaceTarget{}
 	}
 
-	if _, exists := namespaceTarget[jobHash]; !exists {
+	// a job is first seen when its earliest pod was created, so the job
+	// pinned by a conflict resolution doesn't change when the controller
+	// restarts
+	firstSeen := pod.CreationTimestamp.Time
+	if firstSeen.IsZero() {
+		firstSeen = time.Now()
+	}
+	if target, exists := namespaceTarget[jobHash]; !exists {
 		namespaceTarget[jobHash] = &data.NamespaceTarget{
 			Namespace: ns,
 			JobHash:   jobHash,
+			FirstSeen: firstSeen,
 		}
+	} else if firstSeen.Before(target.FirstSeen) {
+		target.FirstSeen = firstSeen
 	}
 	c.namespaceTargets[ns] = namespaceTarget
 }
 
-func (c *ControllerContext) getBaseDomain(ns, jobHash string) string {
-	return fmt.Sprintf("%s-%s.%s", ns, jobHash, c.config.BaseDomain)
+func (c *ControllerContext) getBaseDomain(ns, jobHash string) (string, error) {
+	return c.getRules().ClusterDomain(ns, jobHash, c.getConfig().BaseDomain)
 }
 
 func (c *ControllerContext) reconcileTargets() *data.MonitorConfig {
+	config := c.getConfig()
 	monitorConfig := data.MonitorConfig{
-		BaseDomain:    c.config.BaseDomain,
-		MonitorRanges: []data.MonitorRange{},
-		HaproxyHeader: c.config.HaproxyHeader,
+		BaseDomain:         config.BaseDomain,
+		MonitorRanges:      []data.MonitorRange{},
+		HaproxyHeader:      config.HaproxyHeader,
+		ConflictResolution: config.ConflictResolution,
+		SNIRouting:         config.SNIRouting,
+		RuntimeAPI:         config.RuntimeAPI,
+		Output:             config.Output,
 	}
 
 	logrus.Infof("number of namespaces: %d", len(c.namespaceTargets))
-	for ns, jobs := range c.namespaceTargets {
-		for jobHash, job := range jobs {
+	// ranges are built in a fixed order so the same targets always render
+	// the same configuration
+	namespaces := []string{}
+	for ns := range c.namespaceTargets {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		jobs := c.namespaceTargets[ns]
+		jobHashes := []string{}
+		for jobHash := range jobs {
+			jobHashes = append(jobHashes, jobHash)
+		}
+		sort.Strings(jobHashes)
+		for _, jobHash := range jobHashes {
+			job := jobs[jobHash]
 			ports := []data.MonitorPort{}
 
 			if len(job.APIVIP) > 0 {
@@ -120,9 +237,16 @@ func (c *ControllerContext) reconcileTargets() *data.MonitorConfig {
 			if len(ports) == 0 {
 				continue
 			}
+			baseDomain, err := c.getBaseDomain(ns, jobHash)
+			if err != nil {
+				logrus.Errorf("unable to get the base domain of job %s in %s: %v", jobHash, ns, err)
+				continue
+			}
 			monitorConfig.MonitorRanges = append(monitorConfig.MonitorRanges, data.MonitorRange{
-				BaseDomain:   c.getBaseDomain(ns, jobHash),
+				BaseDomain:   baseDomain,
 				MonitorPorts: ports,
+				Source:       ns + "/" + jobHash,
+				FirstSeen:    job.FirstSeen,
 			})
 		}
 	}
@@ -153,18 +277,32 @@ func (c *ControllerContext) hasConfigUpdated(monitorConfig *data.MonitorConfig)
 	}
 
 	for _, monitorRange := range monitorConfig.MonitorRanges {
-		if _, exists := prevMonitorRangeMap[monitorRange.BaseDomain]; !exists {
+		prevMonitorRange, exists := prevMonitorRangeMap[monitorRange.BaseDomain]
+		if !exists {
 			fmt.Printf("mismatch: base domain %s does not exist", monitorRange.BaseDomain)
 			return true
 		}
+		// changed targets are published too, so a restarted HAProxy
+		// serves the same targets as one updated through the Runtime API
+		if !reflect.DeepEqual(prevMonitorRange.MonitorPorts, monitorRange.MonitorPorts) {
+			logrus.Infof("mismatch: targets of base domain %s have changed", monitorRange.BaseDomain)
+			return true
+		}
 	}
 
 	return false
 }
 
+// Reconcile rebuilds the HAProxy configuration from the current targets and
+// publishes it if it has changed. The outcome is recorded in the reconcile
+// status.
 func (c *ControllerContext) Reconcile() {
 	logrus.Infof("reconciling HAProxy configuration")
 	ctx := context.TODO()
+	if c.getConfig() == nil {
+		logrus.Infof("no monitor config has been loaded, skipping reconcile")
+		return
+	}
 	if err := c.CheckForARecords(); err != nil {
 		log.Printf("error while checking A records: %v", err)
 		return
@@ -174,6 +312,10 @@ func (c *ControllerContext) Reconcile() {
 	defer targetsMutex.Unlock()
 
 	monitorConfig := c.reconcileTargets()
+	c.status.Time = time.Now()
+	c.status.Clusters, c.status.Conflicts = pkg.DiscoveredClusters(monitorConfig.MonitorRanges, monitorConfig.ConflictResolution)
+	pkg.ReportConflicts(c.status.Conflicts)
+	c.status.Err = nil
 
 	if !c.hasConfigUpdated(monitorConfig) {
 		return
@@ -181,12 +323,67 @@ func (c *ControllerContext) Reconcile() {
 
 	logrus.Infof("configuration has updated, building new haproxy configuration")
 
-	content, hash, err := pkg.BuildTargetHAProxyConfig(monitorConfig)
+	var hash string
+	var err error
+	switch monitorConfig.Output.Type {
+	case pkg.OutputDataPlaneAPI:
+		var model *haproxy.Config
+		if _, hash, model, err = pkg.BuildTargetHAProxy(monitorConfig); err != nil {
+			err = fmt.Errorf("unable to build HAProxy config: %v", err)
+		} else if hash != c.status.ConfigHash {
+			// every commit reloads HAProxy, so an unchanged configuration
+			// is not applied again
+			err = c.publishDataPlaneAPI(monitorConfig.Output.DataPlaneAPI, model)
+		}
+	default:
+		var files map[string]string
+		if files, hash, err = pkg.Render(monitorConfig); err != nil {
+			err = fmt.Errorf("unable to render the proxy config: %v", err)
+		} else {
+			err = c.publishConfigMap(ctx, files, hash)
+		}
+	}
 	if err != nil {
-		log.Printf("unable to build HAProxy config: %v", err)
+		log.Printf("%v", err)
+		c.status.Err = err
+		// force the configuration to be rebuilt on the next reconcile
+		c.lastMonitorConfig = nil
 		return
 	}
+	previousHash := c.status.ConfigHash
+	c.status.ConfigHash = hash
+	if hash == previousHash {
+		// only the targets have changed, which doesn't need a restart. The
+		// published files already hold them for the next restart.
+		c.applyRuntimeServers(monitorConfig)
+		return
+	}
+
+	if monitorConfig.Output.Type != pkg.OutputDataPlaneAPI {
+		c.bumpHaproxyDeployment(ctx, hash)
+	}
+}
 
+// applyRuntimeServers updates the servers of the running HAProxy through the
+// Runtime API, if the config has one, so changed targets are served without
+// restarting HAProxy.
+func (c *ControllerContext) applyRuntimeServers(monitorConfig *data.MonitorConfig) {
+	runtimeAPI := pkg.NewRuntimeAPI(monitorConfig.RuntimeAPI)
+	if runtimeAPI == nil {
+		return
+	}
+	model, _, err := pkg.BuildDynamicModel(monitorConfig)
+	if err == nil {
+		err = runtimeAPI.ApplyServers(model)
+	}
+	if err != nil {
+		logrus.Warnf("unable to update HAProxy servers through the runtime API: %v", err)
+	}
+}
+
+// publishConfigMap stores the HAProxy configuration, and any map files it
+// references, in the haproxy config map.
+func (c *ControllerContext) publishConfigMap(ctx context.Context, files map[string]string, hash string) error {
 	cm := corev1.ConfigMap{}
 
 	cmName := types.NamespacedName{
@@ -195,7 +392,7 @@ func (c *ControllerContext) Reconcile() {
 	}
 
 	create := false
-	if err = c.client.Get(ctx, cmName, &cm); err != nil {
+	if err := c.client.Get(ctx, cmName, &cm); err != nil {
 		create = true
 	}
 
@@ -207,25 +404,68 @@ func (c *ControllerContext) Reconcile() {
 				"config-hash": hash,
 			},
 		},
-		Data: map[string]string{
-			"haproxy.cfg": content,
-		},
+		Data: files,
 	}
 
 	if create {
-		if err = c.client.Create(ctx, &cm); err != nil {
-			c.log.V(4).Info("creating haproxy configmap")
-			log.Printf("unable to create config map: %v", err)
-			return
+		c.log.V(4).Info("creating haproxy configmap")
+		if err := c.client.Create(ctx, &cm); err != nil {
+			return fmt.Errorf("unable to create config map: %v", err)
 		}
 	} else {
-		if err = c.client.Update(ctx, &cm); err != nil {
-			log.Printf("error updating haproxy configmap: %v", err)
-			return
+		if err := c.client.Update(ctx, &cm); err != nil {
+			return fmt.Errorf("error updating haproxy configmap: %v", err)
 		}
 	}
+	return nil
+}
+
+// publishDataPlaneAPI applies the frontends and backends of the HAProxy
+// configuration through the HAProxy Data Plane API. The header of the
+// configuration is left to the HAProxy host.
+func (c *ControllerContext) publishDataPlaneAPI(config data.DataPlaneAPIConfig, model *haproxy.Config) error {
+	dataPlaneAPI, err := pkg.NewDataPlaneAPI(config)
+	if err != nil {
+		return err
+	}
+	c.log.V(4).Info("applying haproxy configuration through the data plane api")
+	if err := dataPlaneAPI.Apply(model); err != nil {
+		return fmt.Errorf("unable to apply HAProxy config through the Data Plane API: %v", err)
+	}
+	return nil
+}
 
-	c.bumpHaproxyDeployment(ctx, hash)
+// Status returns the outcome of the most recent reconcile.
+func (c *ControllerContext) Status() ReconcileStatus {
+	targetsMutex.Lock()
+	defer targetsMutex.Unlock()
+	return c.status
+}
+
+// TriggerReconcile requests a reconcile without waiting for the next interval.
+func (c *ControllerContext) TriggerReconcile() {
+	select {
+	case c.reconcileNow <- struct{}{}:
+	default:
+	}
+}
+
+// Run reconciles on every interval, and whenever a reconcile is triggered,
+// until ctx is done.
+func (c *ControllerContext) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Reconcile()
+		case <-c.reconcileNow:
+			c.Reconcile()
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
 func (c *ControllerContext) bumpHaproxyDeployment(ctx context.Context, hash string) {
@@ -263,12 +503,18 @@ func (c *ControllerContext) bumpHaproxyDeployment(ctx context.Context, hash stri
 func (c *ControllerContext) CheckForARecords() error {
 	hostsToCheck := map[string]string{}
 	targetHostCheckMap := map[string]*data.NamespaceTarget{}
+	config := c.getConfig()
+	rules := c.getRules()
 
 	targetsMutex.Lock()
 	for ns, namespaceTarget := range c.namespaceTargets {
 		for hashId, target := range namespaceTarget {
 			if len(target.APIVIP) == 0 {
-				url := fmt.Sprintf("api-int.%s-%s.%s", ns, hashId, c.config.BaseDomain)
+				url, err := rules.LookupHost(ns, hashId, config.BaseDomain)
+				if err != nil {
+					logrus.Errorf("unable to get the lookup host of job %s in %s: %v", hashId, ns, err)
+					continue
+				}
 				hostsToCheck[url] = ""
 				targetHostCheckMap[url] = target
 			}
@@ -288,6 +534,11 @@ func (c *ControllerContext) CheckForARecords() error {
 			if !strings.HasPrefix(ipStr, "10.") {
 				continue
 			}
+			// resolvers return records in any order, the lowest address is
+			// kept so the VIP doesn't change between checks
+			if current := hostsToCheck[host]; len(current) > 0 && util.AddressLess(current, ipStr) {
+				continue
+			}
 			hostsToCheck[host] = ipStr
 		}
 	}
@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdateWithNamespaceRules(t *testing.T) {
+	config := &data.MonitorConfig{
+		BaseDomain: "example.com",
+		NamespaceRules: data.NamespaceRules{
+			Regex:         "^ci-op-[a-z0-9]+$",
+			LabelSelector: "ci.openshift.io/managed=true",
+			JobIdentity: data.JobIdentity{
+				Source: "label",
+				Name:   "job-id",
+			},
+			ClusterDomainTemplate: "{{.JobHash}}.{{.BaseDomain}}",
+		},
+	}
+
+	controllerContext := &ControllerContext{}
+	controllerContext.Initialize(config, nil, "test")
+
+	managed := map[string]string{"ci.openshift.io/managed": "true"}
+	pods := []struct {
+		namespace corev1.Namespace
+		pod       corev1.Pod
+	}{
+		{
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci-op-abc", Labels: managed}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ci-op-abc", Labels: map[string]string{"job-id": "job1"}}},
+		},
+		{
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci-op-def"}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ci-op-def", Labels: map[string]string{"job-id": "job2"}}},
+		},
+		{
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci-ln-ghi", Labels: managed}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ci-ln-ghi", Labels: map[string]string{"job-id": "job3"}}},
+		},
+		{
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci-op-jkl", Labels: managed}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ci-op-jkl"}},
+		},
+	}
+	for _, tt := range pods {
+		controllerContext.Update(&tt.pod, &tt.namespace)
+	}
+
+	if len(controllerContext.namespaceTargets) != 1 {
+		t.Fatalf("expected a single tracked namespace, got %v", controllerContext.namespaceTargets)
+	}
+	if _, exists := controllerContext.namespaceTargets["ci-op-abc"]["job1"]; !exists {
+		t.Fatalf("expected job1 in ci-op-abc to be tracked, got %v", controllerContext.namespaceTargets)
+	}
+
+	// the job is first seen when its earliest pod was created
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{time.Hour, 0, 2 * time.Hour} {
+		pod := pods[0].pod
+		pod.CreationTimestamp = metav1.NewTime(created.Add(offset))
+		controllerContext.Update(&pod, &pods[0].namespace)
+	}
+	if firstSeen := controllerContext.namespaceTargets["ci-op-abc"]["job1"].FirstSeen; !firstSeen.Equal(created) {
+		t.Fatalf("expected job1 to be first seen at %v, got %v", created, firstSeen)
+	}
+
+	baseDomain, err := controllerContext.getBaseDomain("ci-op-abc", "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseDomain != "job1.example.com" {
+		t.Fatalf("expected base domain job1.example.com, got %s", baseDomain)
+	}
+
+	lookupHost, err := controllerContext.getRules().LookupHost("ci-op-abc", "job1", config.BaseDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookupHost != "api-int.job1.example.com" {
+		t.Fatalf("expected lookup host api-int.job1.example.com, got %s", lookupHost)
+	}
+}
+
+func TestHasConfigUpdated(t *testing.T) {
+	monitorConfig := func(targets ...string) *data.MonitorConfig {
+		return &data.MonitorConfig{MonitorRanges: []data.MonitorRange{{
+			BaseDomain:   "job1.example.com",
+			MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api.", Targets: targets}},
+		}}}
+	}
+
+	controllerContext := &ControllerContext{}
+	tests := []struct {
+		name     string
+		config   *data.MonitorConfig
+		expected bool
+	}{
+		{name: "first config", config: monitorConfig("10.0.0.1"), expected: true},
+		{name: "same targets", config: monitorConfig("10.0.0.1"), expected: false},
+		{name: "changed targets", config: monitorConfig("10.0.0.2"), expected: true},
+		{name: "removed base domain", config: &data.MonitorConfig{}, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if updated := controllerContext.hasConfigUpdated(tt.config); updated != tt.expected {
+				t.Fatalf("expected updated to be %t, got %t", tt.expected, updated)
+			}
+		})
+	}
+}
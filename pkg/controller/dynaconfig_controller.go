@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/api/v1alpha1"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// dynaConfigStatusInterval is how often the status of the DynaConfig is
+// refreshed from the outcome of the latest reconcile.
+const dynaConfigStatusInterval = 30 * time.Second
+
+// DynaConfigReconciler applies the monitor config defined by a DynaConfig and
+// reports the discovered clusters and the published configuration in its
+// status.
+type DynaConfigReconciler struct {
+	client.Client
+	Scheme  *runtime.Scheme
+	Context *ControllerContext
+	// Name is the DynaConfig which configures the controller
+	Name types.NamespacedName
+
+	appliedSpecHash string
+}
+
+func monitorPortsFromSpec(ports []v1alpha1.MonitorPort) []data.MonitorPort {
+	if ports == nil {
+		return nil
+	}
+	monitorPorts := []data.MonitorPort{}
+	for _, port := range ports {
+		monitorPorts = append(monitorPorts, data.MonitorPort{
+			Port:        port.Port,
+			Name:        port.Name,
+			PathPrefix:  port.PathPrefix,
+			PathMatch:   port.PathMatch,
+			Protocol:    port.Protocol,
+			SNI:         port.SNI,
+			Rise:        port.Rise,
+			Fall:        port.Fall,
+			HoldTime:    port.HoldTime,
+			DomainRules: port.DomainRules,
+		})
+	}
+	return monitorPorts
+}
+
+func portOverridesFromSpec(overrides map[string]v1alpha1.MonitorPort) map[string]data.MonitorPort {
+	if overrides == nil {
+		return nil
+	}
+	portOverrides := map[string]data.MonitorPort{}
+	for name, port := range overrides {
+		portOverrides[name] = monitorPortsFromSpec([]v1alpha1.MonitorPort{port})[0]
+	}
+	return portOverrides
+}
+
+// monitorConfigSpecFromSpec converts a DynaConfig spec to the monitor config it
+// mirrors.
+func monitorConfigSpecFromSpec(spec *v1alpha1.DynaConfigSpec) data.MonitorConfigSpec {
+	monitorConfig := data.MonitorConfig{
+		MonitorRanges:        []data.MonitorRange{},
+		HaproxyHeader:        spec.HaproxyHeader,
+		CheckTimeout:         spec.CheckTimeout,
+		SubnetsJson:          spec.SubnetsJSONPath,
+		SubnetsPortProfile:   spec.SubnetsPortProfile,
+		SubnetsPortOverrides: portOverridesFromSpec(spec.SubnetsPortOverrides),
+		BaseDomain:           spec.BaseDomain,
+		NamespaceRules: data.NamespaceRules{
+			Prefix:        spec.NamespaceRules.Prefix,
+			Regex:         spec.NamespaceRules.Regex,
+			LabelSelector: spec.NamespaceRules.LabelSelector,
+			JobIdentity: data.JobIdentity{
+				Source: spec.NamespaceRules.JobIdentity.Source,
+				Name:   spec.NamespaceRules.JobIdentity.Name,
+			},
+			ClusterDomainTemplate: spec.NamespaceRules.ClusterDomainTemplate,
+			LookupHostTemplate:    spec.NamespaceRules.LookupHostTemplate,
+		},
+		Scan: data.ScanConfig{
+			Concurrency:      spec.Scan.Concurrency,
+			ProbesPerSecond:  spec.Scan.ProbesPerSecond,
+			Deadline:         spec.Scan.Deadline,
+			FullScanInterval: spec.Scan.FullScanInterval,
+		},
+		CertificateExpiryWarningDays: spec.CertificateExpiryWarningDays,
+		DomainPolicy: data.DomainPolicy{
+			AllowedSuffixes: spec.DomainPolicy.AllowedSuffixes,
+			DeniedDomains:   spec.DomainPolicy.DeniedDomains,
+		},
+		ConflictResolution: spec.ConflictResolution,
+		SNIRouting: data.SNIRoutingConfig{
+			Mode:         spec.SNIRouting.Mode,
+			MapDirectory: spec.SNIRouting.MapDirectory,
+		},
+		RuntimeAPI: data.RuntimeAPIConfig{
+			Address:     spec.RuntimeAPI.Address,
+			ServerSlots: spec.RuntimeAPI.ServerSlots,
+		},
+		Output: data.OutputConfig{
+			Type:     spec.Output.Type,
+			Renderer: spec.Output.Renderer,
+			DataPlaneAPI: data.DataPlaneAPIConfig{
+				URL:          spec.Output.DataPlaneAPI.URL,
+				Username:     spec.Output.DataPlaneAPI.Username,
+				PasswordFile: spec.Output.DataPlaneAPI.PasswordFile,
+				Retries:      spec.Output.DataPlaneAPI.Retries,
+			},
+		},
+	}
+
+	for _, monitorRange := range spec.MonitorRanges {
+		monitorConfig.MonitorRanges = append(monitorConfig.MonitorRanges, data.MonitorRange{
+			IpAddressStart:  monitorRange.IPAddressStart,
+			IpAddressEnd:    monitorRange.IPAddressEnd,
+			IpCidr:          monitorRange.IPCIDR,
+			Exclude:         monitorRange.Exclude,
+			PortProfile:     monitorRange.PortProfile,
+			PortOverrides:   portOverridesFromSpec(monitorRange.PortOverrides),
+			MonitorPorts:    monitorPortsFromSpec(monitorRange.MonitorPorts),
+			ProbesPerSecond: monitorRange.ProbesPerSecond,
+			ExpectedDomains: monitorRange.ExpectedDomains,
+		})
+	}
+
+	if spec.PortProfiles != nil {
+		monitorConfig.PortProfiles = map[string][]data.MonitorPort{}
+		for name, ports := range spec.PortProfiles {
+			monitorConfig.PortProfiles[name] = monitorPortsFromSpec(ports)
+		}
+	}
+	return data.MonitorConfigSpec{MonitorConfig: monitorConfig}
+}
+
+// discoveredClusters converts the clusters of a reconcile to their status.
+func discoveredClusters(clusters []data.MonitorRange) []v1alpha1.DiscoveredCluster {
+	discovered := []v1alpha1.DiscoveredCluster{}
+	for _, cluster := range clusters {
+		discoveredCluster := v1alpha1.DiscoveredCluster{
+			BaseDomain: cluster.BaseDomain,
+		}
+		for _, port := range cluster.MonitorPorts {
+			discoveredCluster.Ports = append(discoveredCluster.Ports, v1alpha1.DiscoveredPort{
+				Port:    port.Port,
+				Targets: port.Targets,
+			})
+		}
+		discovered = append(discovered, discoveredCluster)
+	}
+	return discovered
+}
+
+// domainConflicts converts the conflicts of a reconcile to their status.
+func domainConflicts(conflicts []pkg.DomainConflict) []v1alpha1.DomainConflict {
+	statusConflicts := []v1alpha1.DomainConflict{}
+	for _, conflict := range conflicts {
+		statusConflicts = append(statusConflicts, v1alpha1.DomainConflict{
+			BaseDomain: conflict.BaseDomain,
+			Port:       conflict.Port,
+			Sources:    conflict.Sources,
+			Resolution: conflict.Resolution,
+			Winner:     conflict.Winner,
+		})
+	}
+	return statusConflicts
+}
+
+// applySpec validates the spec and, if it has changed since it was last
+// applied, swaps it in as the monitor config and triggers a reconcile.
+func (r *DynaConfigReconciler) applySpec(dynaConfig *v1alpha1.DynaConfig) {
+	monitorConfigSpec := monitorConfigSpecFromSpec(&dynaConfig.Spec)
+	if errs := pkg.ValidateConfigSpec(&monitorConfigSpec); len(errs) > 0 {
+		meta.SetStatusCondition(&dynaConfig.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionValid,
+			Status:             metav1.ConditionFalse,
+			Reason:             "ValidationFailed",
+			Message:            errs.Error(),
+			ObservedGeneration: dynaConfig.Generation,
+		})
+		return
+	}
+
+	config, err := pkg.PrepareConfig(&monitorConfigSpec)
+	if err != nil {
+		meta.SetStatusCondition(&dynaConfig.Status.Conditions, metav1.Condition{
+			Type:               v1alpha1.ConditionValid,
+			Status:             metav1.ConditionFalse,
+			Reason:             "PrepareFailed",
+			Message:            err.Error(),
+			ObservedGeneration: dynaConfig.Generation,
+		})
+		return
+	}
+
+	meta.SetStatusCondition(&dynaConfig.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ValidationSucceeded",
+		ObservedGeneration: dynaConfig.Generation,
+	})
+
+	specBytes, err := json.Marshal(dynaConfig.Spec)
+	if err != nil {
+		return
+	}
+	specHash := util.GenerateSHA512Hash(specBytes)
+	if specHash == r.appliedSpecHash {
+		return
+	}
+
+	setupLog.Info("applying monitor config", "dynaconfig", r.Name.String(), "generation", dynaConfig.Generation)
+	if err := r.Context.SetConfig(config); err != nil {
+		setupLog.Error(err, "unable to apply monitor config", "dynaconfig", r.Name.String())
+		return
+	}
+	pkg.SetConfig(config)
+	r.Context.TriggerReconcile()
+	r.appliedSpecHash = specHash
+}
+
+// updateStatus reports the outcome of the latest reconcile in the status.
+func (r *DynaConfigReconciler) updateStatus(dynaConfig *v1alpha1.DynaConfig) {
+	reconcileStatus := r.Context.Status()
+	dynaConfig.Status.ObservedGeneration = dynaConfig.Generation
+	if reconcileStatus.Time.IsZero() {
+		return
+	}
+
+	lastReconcileTime := metav1.NewTime(reconcileStatus.Time)
+	dynaConfig.Status.LastReconcileTime = &lastReconcileTime
+	dynaConfig.Status.LastAppliedConfigHash = reconcileStatus.ConfigHash
+	dynaConfig.Status.DiscoveredClusters = discoveredClusters(reconcileStatus.Clusters)
+	dynaConfig.Status.Conflicts = domainConflicts(reconcileStatus.Conflicts)
+
+	applied := metav1.Condition{
+		Type:               v1alpha1.ConditionApplied,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Published",
+		ObservedGeneration: dynaConfig.Generation,
+	}
+	if reconcileStatus.Err != nil {
+		applied.Status = metav1.ConditionFalse
+		applied.Reason = "PublishFailed"
+		applied.Message = reconcileStatus.Err.Error()
+	}
+	meta.SetStatusCondition(&dynaConfig.Status.Conditions, applied)
+}
+
+// +kubebuilder:rbac:groups=haproxy.splat.openshift.io,resources=dynaconfigs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=haproxy.splat.openshift.io,resources=dynaconfigs/status,verbs=get;update;patch
+func (r *DynaConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+
+	if req.NamespacedName != r.Name {
+		return ctrl.Result{}, nil
+	}
+
+	dynaConfig := &v1alpha1.DynaConfig{}
+	if err := r.Client.Get(ctx, req.NamespacedName, dynaConfig); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	r.applySpec(dynaConfig)
+	r.updateStatus(dynaConfig)
+
+	if err := r.Client.Status().Update(ctx, dynaConfig); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: dynaConfigStatusInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. Updates which only
+// change the status are ignored; the status is refreshed on an interval instead.
+func (r *DynaConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.DynaConfig{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
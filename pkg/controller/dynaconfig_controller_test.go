@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+func TestDynaConfigApplySpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    v1alpha1.DynaConfigSpec
+		valid   bool
+		applied bool
+	}{
+		{
+			name: "valid spec is applied",
+			spec: v1alpha1.DynaConfigSpec{
+				BaseDomain: "example.com",
+				MonitorRanges: []v1alpha1.MonitorRange{
+					{
+						IPCIDR:      "192.168.1.0/28",
+						PortProfile: "openshift-default",
+						PortOverrides: map[string]v1alpha1.MonitorPort{
+							"api": {Port: 16443},
+						},
+					},
+				},
+			},
+			valid:   true,
+			applied: true,
+		},
+		{
+			name: "invalid spec is not applied",
+			spec: v1alpha1.DynaConfigSpec{
+				MonitorRanges: []v1alpha1.MonitorRange{
+					{
+						IPAddressStart: "192.168.1.10",
+						IPAddressEnd:   "192.168.1.2",
+						PortProfile:    "openshift-default",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controllerContext := &ControllerContext{}
+			controllerContext.Initialize(nil, nil, "test")
+			reconciler := DynaConfigReconciler{Context: controllerContext}
+
+			dynaConfig := &v1alpha1.DynaConfig{Spec: tt.spec}
+			reconciler.applySpec(dynaConfig)
+
+			if meta.IsStatusConditionTrue(dynaConfig.Status.Conditions, v1alpha1.ConditionValid) != tt.valid {
+				t.Fatalf("expected Valid condition to be %v, got %+v", tt.valid, dynaConfig.Status.Conditions)
+			}
+
+			config := controllerContext.getConfig()
+			if (config != nil) != tt.applied {
+				t.Fatalf("expected config to be applied: %v", tt.applied)
+			}
+			if !tt.applied {
+				return
+			}
+			if config.BaseDomain != "example.com" {
+				t.Fatalf("expected base domain example.com, got %s", config.BaseDomain)
+			}
+			ports := config.MonitorRanges[0].MonitorPorts
+			if len(ports) != 2 || ports[0].Port != 16443 || ports[1].Port != 443 {
+				t.Fatalf("unexpected ports %+v", ports)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// eventRecorder records the events about scanned clusters on an object, such
+// as the namespace the controller runs in.
+type eventRecorder struct {
+	recorder record.EventRecorder
+	object   runtime.Object
+}
+
+// Eventf records an event on the object of the recorder.
+func (r *eventRecorder) Eventf(eventType, reason, messageFmt string, args ...interface{}) {
+	r.recorder.Eventf(r.object, eventType, reason, messageFmt, args...)
+}
+
+// namespaceReference refers to a namespace as the object of an event.
+func namespaceReference(namespace string) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Name:       namespace,
+	}
+}
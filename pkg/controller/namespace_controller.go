@@ -22,13 +22,16 @@ import (
 	"os"
 	"time"
 
+	"github.com/openshift-splat-team/haproxy-dyna-configure/api/v1alpha1"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -66,7 +69,18 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, nil
 	}
 
-	r.Context.Update(pod)
+	ns := &corev1.Namespace{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: pod.Namespace}, ns)
+	if err != nil {
+		// the pod is matched again once the namespace can be read, unless
+		// the namespace is gone
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	r.Context.Update(pod, ns)
 	return ctrl.Result{}, nil
 }
 
@@ -78,6 +92,7 @@ func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 }
 
 // +kubebuilder:rbac:groups=v1,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = log.FromContext(ctx)
 
@@ -104,39 +119,18 @@ func StartManager() {
 	var namespace string
 	var enableLeaderElection bool
 	var probeAddr string
+	var dynaConfigName string
 	controllerContext := &ControllerContext{}
 
-	config, err := pkg.GetConfig()
-	if err != nil {
-		setupLog.Error(err, "unable to get monitor config")
-		os.Exit(1)
-	}
-
 	// Define the interval
 	interval := 30 * time.Second
 
-	// Create a new ticker
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Create a channel to signal the end of the program (optional)
-	done := make(chan bool)
-
-	// Start a goroutine that executes periodically
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				controllerContext.Reconcile()
-			case <-done:
-				return
-			}
-		}
-	}()
-
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&namespace, "namespace", "vsphere-infra-helpers", "The namespace where ")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&dynaConfigName, "dynaconfig", "",
+		"The name of the DynaConfig in the namespace which configures the controller. "+
+			"If not set, the monitor config file is used.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -148,6 +142,17 @@ func StartManager() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	var config *data.MonitorConfig
+	if len(dynaConfigName) == 0 {
+		var err error
+		config, err = pkg.GetConfig()
+		if err != nil {
+			setupLog.Error(err, "unable to get monitor config")
+			os.Exit(1)
+		}
+		pkg.SetConfig(config)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -172,6 +177,11 @@ func StartManager() {
 		setupLog.Error(err, "unable to add appsv1 to scheme")
 		os.Exit(1)
 	}
+	err = v1alpha1.AddToScheme(mgr.GetScheme())
+	if err != nil {
+		setupLog.Error(err, "unable to add v1alpha1 to scheme")
+		os.Exit(1)
+	}
 
 	if err = (&NamespaceReconciler{
 		Client:  client,
@@ -194,6 +204,45 @@ func StartManager() {
 
 	controllerContext.Initialize(config, client, namespace)
 
+	pkg.SetEventRecorder(&eventRecorder{
+		recorder: mgr.GetEventRecorderFor("haproxy-dyna-configure"),
+		object:   namespaceReference(namespace),
+	})
+
+	if len(dynaConfigName) > 0 {
+		if err = (&DynaConfigReconciler{
+			Client:  client,
+			Scheme:  mgr.GetScheme(),
+			Context: controllerContext,
+			Name: types.NamespacedName{
+				Namespace: namespace,
+				Name:      dynaConfigName,
+			},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "dynaconfig")
+			os.Exit(1)
+		}
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	if len(dynaConfigName) == 0 {
+		err = pkg.WatchConfig(ctx, func(config *data.MonitorConfig) {
+			setupLog.Info("monitor config reloaded")
+			if err := controllerContext.SetConfig(config); err != nil {
+				setupLog.Error(err, "unable to apply reloaded monitor config")
+				return
+			}
+			pkg.SetConfig(config)
+			controllerContext.TriggerReconcile()
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to watch monitor config")
+			os.Exit(1)
+		}
+	}
+
+	go controllerContext.Run(ctx, interval)
+
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -207,7 +256,7 @@ func StartManager() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -1 +1,67 @@
 package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podClient returns a pod and fails to get its namespace with namespaceErr.
+type podClient struct {
+	client.Client
+	namespaceErr error
+}
+
+func (c *podClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	switch obj := obj.(type) {
+	case *corev1.Pod:
+		obj.ObjectMeta = metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace}
+		return nil
+	case *corev1.Namespace:
+		return c.namespaceErr
+	}
+	return fmt.Errorf("unexpected object %T", obj)
+}
+
+func TestPodReconcilerNamespaceErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		namespaceErr error
+		expectErr    bool
+	}{
+		{
+			name:         "namespace not found",
+			namespaceErr: apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "ci-op-abc"),
+		},
+		{
+			name:         "namespace unavailable",
+			namespaceErr: apierrors.NewServiceUnavailable("unavailable"),
+			expectErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controllerContext := &ControllerContext{}
+			controllerContext.Initialize(&data.MonitorConfig{}, nil, "test")
+			reconciler := &PodReconciler{
+				Client:  &podClient{namespaceErr: tt.namespaceErr},
+				Context: controllerContext,
+			}
+			_, err := reconciler.Reconcile(context.TODO(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: "ci-op-abc", Name: "pod"},
+			})
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("expected error to be %t, got %v", tt.expectErr, err)
+			}
+		})
+	}
+}
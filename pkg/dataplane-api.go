@@ -0,0 +1,496 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// Outputs the controller publishes the HAProxy configuration to.
+const (
+	// OutputConfigMap stores the configuration in the haproxy config map and
+	// restarts the HAProxy deployment
+	OutputConfigMap = "config-map"
+	// OutputDataPlaneAPI applies the frontends and backends through the
+	// HAProxy Data Plane API
+	OutputDataPlaneAPI = "data-plane-api"
+)
+
+const (
+	// dataPlaneAPITimeout bounds each request to the Data Plane API
+	dataPlaneAPITimeout = 30 * time.Second
+	// defaultDataPlaneAPIRetries is how many times a conflicting transaction
+	// is retried unless the config sets otherwise
+	defaultDataPlaneAPIRetries = 3
+	// dataPlaneAPIConfiguration is the path of the configuration resources
+	dataPlaneAPIConfiguration = "/services/haproxy/configuration"
+	// dataPlaneAPITransactions is the path of the transactions
+	dataPlaneAPITransactions = "/services/haproxy/transactions"
+	// dynamicFrontendPrefix names the frontends built by the dynamic
+	// configuration, which are the ones the Data Plane API sink owns
+	dynamicFrontendPrefix = "dynaconfig-fe-"
+)
+
+// DataPlaneAPI applies the frontends and backends of a configuration through
+// the HAProxy Data Plane API. Each apply is a single transaction, so HAProxy
+// sees either none or all of the changes.
+type DataPlaneAPI struct {
+	// URL is the base URL of the API, such as http://haproxy:5555/v2
+	URL      string
+	Username string
+	Password string
+	// Retries is how many times a transaction which conflicts with another
+	// change is retried
+	Retries int
+	Client  *http.Client
+}
+
+// NewDataPlaneAPI returns the Data Plane API of the config, reading the
+// password from its file.
+func NewDataPlaneAPI(config data.DataPlaneAPIConfig) (*DataPlaneAPI, error) {
+	api := &DataPlaneAPI{
+		URL:      strings.TrimSuffix(config.URL, "/"),
+		Username: config.Username,
+		Retries:  config.Retries,
+		Client:   &http.Client{Timeout: dataPlaneAPITimeout},
+	}
+	if api.Retries == 0 {
+		api.Retries = defaultDataPlaneAPIRetries
+	}
+	if len(config.PasswordFile) > 0 {
+		password, err := os.ReadFile(config.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the Data Plane API password: %v", err)
+		}
+		api.Password = strings.TrimSpace(string(password))
+	}
+	return api, nil
+}
+
+// dataPlaneAPIError is a response of the Data Plane API which isn't a success.
+type dataPlaneAPIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *dataPlaneAPIError) Error() string {
+	return fmt.Sprintf("%s %s returned %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// conflict reports whether the request failed because the configuration was
+// changed by someone else.
+func (e *dataPlaneAPIError) conflict() bool {
+	return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusNotAcceptable
+}
+
+// do sends a request with a JSON body, if any, and decodes the JSON response
+// into out, if set.
+func (d *DataPlaneAPI) do(method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("unable to encode the request to %s: %v", path, err)
+		}
+		reader = bytes.NewReader(content)
+	}
+	target := d.URL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return fmt.Errorf("unable to create the request to %s: %v", path, err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if len(d.Username) > 0 {
+		request.SetBasicAuth(d.Username, d.Password)
+	}
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("unable to send %s %s: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read the response to %s %s: %v", method, path, err)
+	}
+	if response.StatusCode >= 300 {
+		message := strings.TrimSpace(string(content))
+		errorResponse := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(content, &errorResponse) == nil && len(errorResponse.Message) > 0 {
+			message = errorResponse.Message
+		}
+		return &dataPlaneAPIError{Method: method, Path: path, StatusCode: response.StatusCode, Message: message}
+	}
+	if out != nil {
+		if err := json.Unmarshal(content, out); err != nil {
+			return fmt.Errorf("unable to decode the response to %s %s: %v", method, path, err)
+		}
+	}
+	return nil
+}
+
+// Apply replaces the frontends and backends the sink owns with those of the
+// config. The sink owns the dynaconfig frontends and the backends they route
+// to, anything else configured through the API is left alone. Transactions
+// which conflict with another change are retried. An invalid config is
+// refused before a transaction is started.
+func (d *DataPlaneAPI) Apply(config *haproxy.Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	var err error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		err = d.apply(config)
+		if apiErr, ok := err.(*dataPlaneAPIError); !ok || !apiErr.conflict() {
+			return err
+		}
+		logrus.Warnf("Data Plane API transaction conflicted with another change, retrying: %v", err)
+	}
+	return fmt.Errorf("giving up after %d conflicting transactions: %v", d.Retries+1, err)
+}
+
+func (d *DataPlaneAPI) apply(config *haproxy.Config) error {
+	var version int64
+	if err := d.do(http.MethodGet, dataPlaneAPIConfiguration+"/version", nil, nil, &version); err != nil {
+		return err
+	}
+	transaction := struct {
+		ID string `json:"id"`
+	}{}
+	if err := d.do(http.MethodPost, dataPlaneAPITransactions, url.Values{"version": {strconv.FormatInt(version, 10)}}, nil, &transaction); err != nil {
+		return err
+	}
+
+	if err := d.replace(config, transaction.ID); err != nil {
+		// the transaction is abandoned, it is only deleted to keep the
+		// API tidy
+		if deleteErr := d.do(http.MethodDelete, dataPlaneAPITransactions+"/"+transaction.ID, nil, nil, nil); deleteErr != nil {
+			logrus.Warnf("unable to delete Data Plane API transaction %s: %v", transaction.ID, deleteErr)
+		}
+		return err
+	}
+	return d.do(http.MethodPut, dataPlaneAPITransactions+"/"+transaction.ID, nil, nil, nil)
+}
+
+// dataPlaneAPIList is the response to a request for a list of resources.
+type dataPlaneAPIList struct {
+	Data []map[string]interface{} `json:"data"`
+}
+
+// names returns a field of each resource of the list.
+func (l *dataPlaneAPIList) names(field string) []string {
+	names := []string{}
+	for _, resource := range l.Data {
+		if name, exists := resource[field]; exists {
+			names = append(names, fmt.Sprint(name))
+		}
+	}
+	return names
+}
+
+// replace makes the changes of an apply in the transaction.
+func (d *DataPlaneAPI) replace(config *haproxy.Config, transactionID string) error {
+	inTransaction := func(values url.Values) url.Values {
+		query := url.Values{"transaction_id": {transactionID}}
+		for key, value := range values {
+			query[key] = value
+		}
+		return query
+	}
+
+	// the backends the sink owns are those its frontends route to
+	frontends := dataPlaneAPIList{}
+	if err := d.do(http.MethodGet, dataPlaneAPIConfiguration+"/frontends", inTransaction(nil), nil, &frontends); err != nil {
+		return err
+	}
+	existingFrontends := map[string]bool{}
+	ownedBackends := []string{}
+	for _, name := range frontends.names("name") {
+		if !strings.HasPrefix(name, dynamicFrontendPrefix) {
+			continue
+		}
+		existingFrontends[name] = true
+		rules := dataPlaneAPIList{}
+		if err := d.do(http.MethodGet, dataPlaneAPIConfiguration+"/backend_switching_rules", inTransaction(url.Values{"frontend": {name}}), nil, &rules); err != nil {
+			return err
+		}
+		ownedBackends = append(ownedBackends, rules.names("name")...)
+	}
+	backends := dataPlaneAPIList{}
+	if err := d.do(http.MethodGet, dataPlaneAPIConfiguration+"/backends", inTransaction(nil), nil, &backends); err != nil {
+		return err
+	}
+	existingBackends := map[string]bool{}
+	for _, name := range backends.names("name") {
+		existingBackends[name] = true
+	}
+
+	// frontends are removed before the backends they route to
+	wanted := map[string]bool{}
+	for _, frontend := range config.Frontends {
+		wanted[frontend.Name] = true
+	}
+	for _, name := range frontends.names("name") {
+		if existingFrontends[name] && !wanted[name] {
+			if err := d.do(http.MethodDelete, dataPlaneAPIConfiguration+"/frontends/"+url.PathEscape(name), inTransaction(nil), nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+	for _, backend := range config.Backends {
+		wanted[backend.Name] = true
+	}
+	for _, name := range ownedBackends {
+		if existingBackends[name] && !wanted[name] {
+			// a backend may be routed to by more than one frontend
+			existingBackends[name] = false
+			if err := d.do(http.MethodDelete, dataPlaneAPIConfiguration+"/backends/"+url.PathEscape(name), inTransaction(nil), nil, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	// a resource is created, or replaced along with every child, so it
+	// ends up exactly as the config has it
+	createOrReplace := func(collection, name string, exists bool, resource interface{}) error {
+		if exists {
+			return d.do(http.MethodPut, dataPlaneAPIConfiguration+"/"+collection+"/"+url.PathEscape(name), inTransaction(nil), resource, nil)
+		}
+		return d.do(http.MethodPost, dataPlaneAPIConfiguration+"/"+collection, inTransaction(nil), resource, nil)
+	}
+	replaceChildren := func(collection, key string, parent url.Values, children []interface{}) error {
+		existing := dataPlaneAPIList{}
+		if err := d.do(http.MethodGet, dataPlaneAPIConfiguration+"/"+collection, inTransaction(parent), nil, &existing); err != nil {
+			return err
+		}
+		// indexed children are deleted last first so the indexes of the
+		// rest don't move
+		names := existing.names(key)
+		for idx := len(names) - 1; idx >= 0; idx-- {
+			if err := d.do(http.MethodDelete, dataPlaneAPIConfiguration+"/"+collection+"/"+url.PathEscape(names[idx]), inTransaction(parent), nil, nil); err != nil {
+				return err
+			}
+		}
+		for _, child := range children {
+			if err := d.do(http.MethodPost, dataPlaneAPIConfiguration+"/"+collection, inTransaction(parent), child, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, backend := range config.Backends {
+		resource, err := dataPlaneBackend(backend)
+		if err != nil {
+			return err
+		}
+		if err := createOrReplace("backends", backend.Name, existingBackends[backend.Name], resource); err != nil {
+			return err
+		}
+		servers := []interface{}{}
+		for idx := range backend.Servers {
+			server, err := dataPlaneServer(&backend.Servers[idx])
+			if err != nil {
+				return fmt.Errorf("backend %s: %v", backend.Name, err)
+			}
+			servers = append(servers, server)
+		}
+		if err := replaceChildren("servers", "name", url.Values{"backend": {backend.Name}}, servers); err != nil {
+			return err
+		}
+		templates := []interface{}{}
+		for idx := range backend.ServerTemplates {
+			template, err := dataPlaneServerTemplate(&backend.ServerTemplates[idx])
+			if err != nil {
+				return fmt.Errorf("backend %s: %v", backend.Name, err)
+			}
+			templates = append(templates, template)
+		}
+		if err := replaceChildren("server_templates", "prefix", url.Values{"backend": {backend.Name}}, templates); err != nil {
+			return err
+		}
+	}
+
+	for _, frontend := range config.Frontends {
+		resource, err := dataPlaneFrontend(frontend)
+		if err != nil {
+			return err
+		}
+		if err := createOrReplace("frontends", frontend.Name, existingFrontends[frontend.Name], resource); err != nil {
+			return err
+		}
+		binds := []interface{}{}
+		for idx := range frontend.Binds {
+			bind, err := dataPlaneBind(&frontend.Binds[idx])
+			if err != nil {
+				return fmt.Errorf("frontend %s: %v", frontend.Name, err)
+			}
+			binds = append(binds, bind)
+		}
+		if err := replaceChildren("binds", "name", url.Values{"frontend": {frontend.Name}}, binds); err != nil {
+			return err
+		}
+		acls := []interface{}{}
+		for idx, acl := range frontend.ACLs {
+			acls = append(acls, map[string]interface{}{
+				"index":     idx,
+				"acl_name":  acl.Name,
+				"criterion": acl.Criterion,
+				"value":     strings.Join(acl.Values, " "),
+			})
+		}
+		if err := replaceChildren("acls", "index", url.Values{"parent_type": {"frontend"}, "parent_name": {frontend.Name}}, acls); err != nil {
+			return err
+		}
+		tcpRules := []interface{}{}
+		for _, rule := range frontend.TCPRequestRules {
+			tcpRule := withDataPlaneCondition(map[string]interface{}{
+				"index":  len(tcpRules),
+				"type":   "content",
+				"action": rule.Action,
+			}, rule.Condition)
+			tcpRules = append(tcpRules, tcpRule)
+		}
+		if frontend.InspectDelay > 0 {
+			tcpRules = append(tcpRules, map[string]interface{}{
+				"index":   len(tcpRules),
+				"type":    "inspect-delay",
+				"timeout": frontend.InspectDelay.Milliseconds(),
+			})
+		}
+		if err := replaceChildren("tcp_request_rules", "index", url.Values{"parent_type": {"frontend"}, "parent_name": {frontend.Name}}, tcpRules); err != nil {
+			return err
+		}
+		switchingRules := []interface{}{}
+		for idx, useBackend := range frontend.UseBackends {
+			switchingRules = append(switchingRules, withDataPlaneCondition(map[string]interface{}{
+				"index": idx,
+				"name":  useBackend.Backend,
+			}, useBackend.Condition))
+		}
+		if err := replaceChildren("backend_switching_rules", "index", url.Values{"frontend": {frontend.Name}}, switchingRules); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withDataPlaneCondition adds a condition to a rule.
+func withDataPlaneCondition(rule map[string]interface{}, condition *haproxy.Condition) map[string]interface{} {
+	if condition != nil {
+		rule["cond"] = "if"
+		if condition.Unless {
+			rule["cond"] = "unless"
+		}
+		rule["cond_test"] = condition.Expression
+	}
+	return rule
+}
+
+func dataPlaneFrontend(frontend *haproxy.Frontend) (map[string]interface{}, error) {
+	if len(frontend.Directives) > 0 {
+		return nil, fmt.Errorf("frontend %s has directives which can't be applied through the Data Plane API", frontend.Name)
+	}
+	resource := map[string]interface{}{"name": frontend.Name}
+	if len(frontend.Mode) > 0 {
+		resource["mode"] = string(frontend.Mode)
+	}
+	if len(frontend.DefaultBackend) > 0 {
+		resource["default_backend"] = frontend.DefaultBackend
+	}
+	return resource, nil
+}
+
+func dataPlaneBind(bind *haproxy.Bind) (map[string]interface{}, error) {
+	resource := map[string]interface{}{
+		"name":    net.JoinHostPort(bind.Address, strconv.FormatInt(bind.Port, 10)),
+		"address": bind.Address,
+		"port":    bind.Port,
+	}
+	for _, option := range bind.Options {
+		switch option {
+		case "v6only":
+			resource["v6only"] = true
+		default:
+			return nil, fmt.Errorf("bind option %q can't be applied through the Data Plane API", option)
+		}
+	}
+	return resource, nil
+}
+
+func dataPlaneBackend(backend *haproxy.Backend) (map[string]interface{}, error) {
+	if len(backend.Directives) > 0 {
+		return nil, fmt.Errorf("backend %s has directives which can't be applied through the Data Plane API", backend.Name)
+	}
+	resource := map[string]interface{}{"name": backend.Name}
+	if len(backend.Mode) > 0 {
+		resource["mode"] = string(backend.Mode)
+	}
+	if len(backend.Balance) > 0 {
+		resource["balance"] = map[string]interface{}{"algorithm": backend.Balance}
+	}
+	return resource, nil
+}
+
+// dataPlaneServerOptions sets the fields of a server or server template from
+// its options.
+func dataPlaneServerOptions(resource map[string]interface{}, check bool, options []string) error {
+	if check {
+		resource["check"] = "enabled"
+	}
+	for _, option := range options {
+		switch option {
+		case "verify none":
+			resource["verify"] = "none"
+		case "disabled":
+			resource["maintenance"] = "enabled"
+		default:
+			return fmt.Errorf("server option %q can't be applied through the Data Plane API", option)
+		}
+	}
+	return nil
+}
+
+func dataPlaneServer(server *haproxy.Server) (map[string]interface{}, error) {
+	resource := map[string]interface{}{
+		"name":    server.Name,
+		"address": server.Address,
+		"port":    server.Port,
+	}
+	return resource, dataPlaneServerOptions(resource, server.Check, server.Options)
+}
+
+func dataPlaneServerTemplate(template *haproxy.ServerTemplate) (map[string]interface{}, error) {
+	resource := map[string]interface{}{
+		"prefix":       template.Prefix,
+		"num_or_range": fmt.Sprintf("%d-%d", template.First, template.Last),
+		"fqdn":         template.Address,
+		"port":         template.Port,
+	}
+	return resource, dataPlaneServerOptions(resource, template.Check, template.Options)
+}
@@ -0,0 +1,276 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+)
+
+// fakeDataPlaneAPI keeps the resources of the Data Plane API in memory. Each
+// list of resources is keyed by its collection and parent, such as
+// servers?backend=name, and changed within transactions.
+type fakeDataPlaneAPI struct {
+	lock         sync.Mutex
+	version      int64
+	resources    map[string][]map[string]interface{}
+	transactions map[string]map[string][]map[string]interface{}
+	// conflicts is how many commits are rejected as if someone else had
+	// changed the configuration
+	conflicts int
+	commits   int
+}
+
+func newFakeDataPlaneAPI(t *testing.T, username, password string) (*fakeDataPlaneAPI, *httptest.Server) {
+	fake := &fakeDataPlaneAPI{
+		version:      1,
+		resources:    map[string][]map[string]interface{}{},
+		transactions: map[string]map[string][]map[string]interface{}{},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != username || pass != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fake.lock.Lock()
+		defer fake.lock.Unlock()
+		status, body := fake.serve(r)
+		w.WriteHeader(status)
+		if body != nil {
+			_ = json.NewEncoder(w).Encode(body)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+// fakeDataPlaneAPIKeys are the fields which identify the resources of a
+// collection, the rest are identified by name.
+var fakeDataPlaneAPIKeys = map[string]string{
+	"server_templates":        "prefix",
+	"acls":                    "index",
+	"tcp_request_rules":       "index",
+	"backend_switching_rules": "index",
+}
+
+func (f *fakeDataPlaneAPI) serve(r *http.Request) (int, interface{}) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/services/haproxy")
+	query := r.URL.Query()
+
+	if path == "/configuration/version" {
+		return http.StatusOK, f.version
+	}
+	if path == "/transactions" && r.Method == http.MethodPost {
+		if query.Get("version") != fmt.Sprint(f.version) {
+			return http.StatusConflict, map[string]string{"message": "version mismatch"}
+		}
+		id := fmt.Sprintf("transaction-%d", len(f.transactions)+1)
+		staged := map[string][]map[string]interface{}{}
+		for key, list := range f.resources {
+			staged[key] = append([]map[string]interface{}{}, list...)
+		}
+		f.transactions[id] = staged
+		return http.StatusCreated, map[string]interface{}{"id": id, "_version": f.version, "status": "in_progress"}
+	}
+	if strings.HasPrefix(path, "/transactions/") {
+		id := strings.TrimPrefix(path, "/transactions/")
+		staged, exists := f.transactions[id]
+		if !exists {
+			return http.StatusNotFound, map[string]string{"message": "no such transaction"}
+		}
+		delete(f.transactions, id)
+		if r.Method == http.MethodDelete {
+			return http.StatusNoContent, nil
+		}
+		f.version++
+		if f.conflicts > 0 {
+			f.conflicts--
+			return http.StatusConflict, map[string]string{"message": "version mismatch"}
+		}
+		f.resources = staged
+		f.commits++
+		return http.StatusAccepted, nil
+	}
+
+	staged, exists := f.transactions[query.Get("transaction_id")]
+	if !strings.HasPrefix(path, "/configuration/") || !exists {
+		return http.StatusBadRequest, map[string]string{"message": "changes must be made in a transaction"}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, "/configuration/"), "/", 2)
+	query.Del("transaction_id")
+	listKey := parts[0]
+	if len(query) > 0 {
+		listKey += "?" + query.Encode()
+	}
+	keyField := "name"
+	if field, exists := fakeDataPlaneAPIKeys[parts[0]]; exists {
+		keyField = field
+	}
+	find := func(key string) int {
+		for idx, resource := range staged[listKey] {
+			if fmt.Sprint(resource[keyField]) == key {
+				return idx
+			}
+		}
+		return -1
+	}
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 1:
+		return http.StatusOK, map[string]interface{}{"_version": f.version, "data": staged[listKey]}
+	case r.Method == http.MethodPost && len(parts) == 1:
+		resource := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		if keyField != "index" && find(fmt.Sprint(resource[keyField])) >= 0 {
+			return http.StatusBadRequest, map[string]string{"message": "already exists"}
+		}
+		staged[listKey] = append(staged[listKey], resource)
+		return http.StatusCreated, resource
+	case r.Method == http.MethodPut && len(parts) == 2:
+		idx := find(parts[1])
+		if idx < 0 {
+			return http.StatusNotFound, map[string]string{"message": "not found"}
+		}
+		resource := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&resource); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		staged[listKey][idx] = resource
+		return http.StatusOK, resource
+	case r.Method == http.MethodDelete && len(parts) == 2:
+		idx := find(parts[1])
+		if idx < 0 {
+			return http.StatusNotFound, map[string]string{"message": "not found"}
+		}
+		staged[listKey] = append(staged[listKey][:idx], staged[listKey][idx+1:]...)
+		// the children of a deleted frontend or backend go with it
+		for key := range staged {
+			if strings.Contains(key, "="+url.QueryEscape(parts[1])) {
+				delete(staged, key)
+			}
+		}
+		return http.StatusNoContent, nil
+	}
+	return http.StatusMethodNotAllowed, map[string]string{"message": "unsupported"}
+}
+
+// names returns a field of each resource of a list.
+func (f *fakeDataPlaneAPI) names(listKey, field string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	names := []string{}
+	for _, resource := range f.resources[listKey] {
+		names = append(names, fmt.Sprint(resource[field]))
+	}
+	return names
+}
+
+func TestDataPlaneAPIApply(t *testing.T) {
+	fake, server := newFakeDataPlaneAPI(t, "admin", "secret")
+	fake.resources = map[string][]map[string]interface{}{
+		"frontends": {{"name": "stats"}, {"name": "dynaconfig-fe-6443", "mode": "tcp"}},
+		"backend_switching_rules?frontend=dynaconfig-fe-6443": {
+			{"index": 0, "name": "old.example.com-6443", "cond": "if", "cond_test": "{ req.ssl_sni -i api.old.example.com }"},
+		},
+		"backends":                             {{"name": "stats-backend"}, {"name": "old.example.com-6443"}},
+		"servers?backend=old.example.com-6443": {{"name": "192.168.1.9-6443", "address": "192.168.1.9", "port": 6443}},
+	}
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dataPlaneAPI, err := NewDataPlaneAPI(data.DataPlaneAPIConfig{URL: server.URL + "/v2/", Username: "admin", PasswordFile: passwordFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, _, err := BuildDynamicModel(&data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{{
+			BaseDomain: "cluster.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.4", "192.168.1.5"}},
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.4"}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dataPlaneAPI.Apply(model); err != nil {
+		t.Fatal(err)
+	}
+
+	// the owned frontends and backends are replaced, the rest are left
+	// alone
+	expectNames := func(listKey, field string, expected ...string) {
+		t.Helper()
+		names := fake.names(listKey, field)
+		sort.Strings(names)
+		sort.Strings(expected)
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected %s %v, got %v", listKey, expected, names)
+		}
+	}
+	expectNames("frontends", "name", "stats", "dynaconfig-fe-443", "dynaconfig-fe-6443")
+	expectNames("backends", "name", "stats-backend", "cluster.example.com-443", "cluster.example.com-6443")
+	expectNames("servers?backend=cluster.example.com-6443", "address", "192.168.1.4", "192.168.1.5")
+	expectNames("binds?frontend=dynaconfig-fe-6443", "name", "0.0.0.0:16443")
+	expectNames("backend_switching_rules?frontend=dynaconfig-fe-6443", "cond_test", "{ req.ssl_sni -i api.cluster.example.com api-int.cluster.example.com }")
+	expectNames("tcp_request_rules?parent_name=dynaconfig-fe-443&parent_type=frontend", "type", "content", "inspect-delay")
+	if fake.commits != 1 || fake.version != 2 || len(fake.transactions) != 0 {
+		t.Fatalf("expected a single committed transaction, got %d commits at version %d", fake.commits, fake.version)
+	}
+
+	// conflicting transactions are retried until they run out
+	fake.conflicts = 2
+	if err := dataPlaneAPI.Apply(model); err != nil {
+		t.Fatal(err)
+	}
+	if fake.commits != 2 {
+		t.Fatalf("expected the conflicting transaction to be retried, got %d commits", fake.commits)
+	}
+	fake.conflicts = dataPlaneAPI.Retries + 1
+	if err := dataPlaneAPI.Apply(model); err == nil || !strings.Contains(err.Error(), "giving up") {
+		t.Fatalf("expected the retries to run out, got %v", err)
+	}
+
+	// a configuration the API can't express is refused before it's committed
+	model.Backends[0].Directives = []string{"option tcp-check"}
+	if err := dataPlaneAPI.Apply(model); err == nil || !strings.Contains(err.Error(), "directives") {
+		t.Fatalf("expected the directives to be refused, got %v", err)
+	}
+	if len(fake.transactions) != 0 {
+		t.Fatalf("expected the failed transaction to be deleted, got %v", fake.transactions)
+	}
+
+	// an invalid configuration is refused before a transaction is started
+	commits := fake.commits
+	model.Backends[0].Directives = nil
+	useBackends := model.Frontends[0].UseBackends
+	model.Frontends[0].AddUseBackend(haproxy.UseBackend{Backend: "missing"})
+	if err := dataPlaneAPI.Apply(model); err == nil || !strings.Contains(err.Error(), "unknown backend missing") {
+		t.Fatalf("expected the invalid configuration to be refused, got %v", err)
+	}
+	if fake.commits != commits || len(fake.transactions) != 0 {
+		t.Fatal("expected no transaction for the invalid configuration")
+	}
+	model.Frontends[0].UseBackends = useBackends
+
+	dataPlaneAPI.Password = "wrong"
+	if err := dataPlaneAPI.Apply(model); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("expected the wrong password to be refused, got %v", err)
+	}
+}
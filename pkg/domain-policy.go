@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// Reasons a base domain is rejected by the domain policy.
+const (
+	DomainRejectedDenied     = "denied"
+	DomainRejectedNotAllowed = "not-allowed"
+	DomainRejectedUnexpected = "unexpected"
+)
+
+// DomainRejectedError is returned when a base domain found by a scan fails the
+// domain policy.
+type DomainRejectedError struct {
+	Domain string
+	Reason string
+	Detail string
+}
+
+func (e *DomainRejectedError) Error() string {
+	return fmt.Sprintf("base domain %s rejected (%s): %s", e.Domain, e.Reason, e.Detail)
+}
+
+// compiledExpectedDomains caches the compiled expected domain patterns by
+// their expression.
+var compiledExpectedDomains sync.Map
+
+// compileExpectedDomain compiles an expected domain pattern so that it must
+// match the whole of a domain.
+func compileExpectedDomain(pattern string) (*regexp.Regexp, error) {
+	if compiled, exists := compiledExpectedDomains.Load(pattern); exists {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	compiledExpectedDomains.Store(pattern, compiled)
+	return compiled, nil
+}
+
+// domainWithin reports whether a domain is parent or one of its subdomains.
+func domainWithin(domain, parent string) bool {
+	parent = normalizeDomain(parent)
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+// checkDomainPolicy checks a base domain found in a range against the domain
+// policy. Denied domains are checked first, then the allowed suffixes and
+// finally the expected domains of the range.
+func checkDomainPolicy(policy *data.DomainPolicy, monitorRange *data.MonitorRange, domain string) *DomainRejectedError {
+	domain = normalizeDomain(domain)
+	for _, denied := range policy.DeniedDomains {
+		if domainWithin(domain, denied) {
+			return &DomainRejectedError{Domain: domain, Reason: DomainRejectedDenied, Detail: fmt.Sprintf("within denied domain %s", denied)}
+		}
+	}
+
+	if len(policy.AllowedSuffixes) > 0 {
+		allowed := false
+		for _, suffix := range policy.AllowedSuffixes {
+			if domainWithin(domain, suffix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return &DomainRejectedError{Domain: domain, Reason: DomainRejectedNotAllowed, Detail: "not within an allowed suffix"}
+		}
+	}
+
+	if len(monitorRange.ExpectedDomains) > 0 {
+		for _, pattern := range monitorRange.ExpectedDomains {
+			// patterns are validated when the config is loaded
+			if compiled, err := compileExpectedDomain(pattern); err == nil && compiled.MatchString(domain) {
+				return nil
+			}
+		}
+		return &DomainRejectedError{Domain: domain, Reason: DomainRejectedUnexpected, Detail: fmt.Sprintf("not expected in range %s", RangeKey(monitorRange))}
+	}
+	return nil
+}
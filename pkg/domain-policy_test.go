@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestCheckDomainPolicy(t *testing.T) {
+	policy := &data.DomainPolicy{
+		AllowedSuffixes: []string{"ci.example.com", "lab.example.com."},
+		DeniedDomains:   []string{"prod.ci.example.com"},
+	}
+	monitorRange := &data.MonitorRange{
+		IpCidr:          "192.168.1.0/24",
+		ExpectedDomains: []string{`ci-op-[a-z0-9]+\.ci\.example\.com`, `.*\.lab\.example\.com`},
+	}
+
+	tests := []struct {
+		name         string
+		policy       *data.DomainPolicy
+		monitorRange *data.MonitorRange
+		domain       string
+		reason       string
+	}{
+		{name: "empty policy", policy: &data.DomainPolicy{}, monitorRange: &data.MonitorRange{}, domain: "anything.example.org"},
+		{name: "expected domain", policy: policy, monitorRange: monitorRange, domain: "ci-op-abc123.ci.example.com"},
+		{name: "trailing dot and case", policy: policy, monitorRange: monitorRange, domain: "CI-OP-ABC123.ci.example.com."},
+		{name: "allowed suffix with trailing dot", policy: policy, monitorRange: monitorRange, domain: "vsphere.lab.example.com"},
+		{name: "denied domain", policy: policy, monitorRange: monitorRange, domain: "prod.ci.example.com", reason: DomainRejectedDenied},
+		{name: "subdomain of denied domain", policy: policy, monitorRange: monitorRange, domain: "east.prod.ci.example.com", reason: DomainRejectedDenied},
+		{name: "outside allowed suffixes", policy: policy, monitorRange: monitorRange, domain: "ci-op-abc123.example.org", reason: DomainRejectedNotAllowed},
+		{name: "suffix must match whole labels", policy: policy, monitorRange: monitorRange, domain: "evilci.example.com", reason: DomainRejectedNotAllowed},
+		{name: "unexpected in range", policy: policy, monitorRange: monitorRange, domain: "other.ci.example.com", reason: DomainRejectedUnexpected},
+		{name: "pattern must match whole domain", policy: &data.DomainPolicy{}, monitorRange: monitorRange, domain: "ci-op-abc123.ci.example.com.evil.org", reason: DomainRejectedUnexpected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rejection := checkDomainPolicy(tt.policy, tt.monitorRange, tt.domain)
+			reason := ""
+			if rejection != nil {
+				reason = rejection.Reason
+			}
+			if reason != tt.reason {
+				t.Fatalf("expected reason %q, got %q (%v)", tt.reason, reason, rejection)
+			}
+		})
+	}
+}
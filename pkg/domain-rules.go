@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// domainGroup is the capture group of a domain rule holding the base domain.
+const domainGroup = "domain"
+
+// compiledDomainRules caches the compiled domain rules by their expression.
+var compiledDomainRules sync.Map
+
+// compileDomainRule compiles a domain rule, checking that it has a domain
+// capture group.
+func compileDomainRule(rule string) (*regexp.Regexp, error) {
+	if compiled, exists := compiledDomainRules.Load(rule); exists {
+		return compiled.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile(rule)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.SubexpIndex(domainGroup) < 0 {
+		return nil, fmt.Errorf("has no (?P<%s>...) capture group", domainGroup)
+	}
+	compiledDomainRules.Store(rule, compiled)
+	return compiled, nil
+}
+
+// domainRules returns the domain rules of a port. If none are configured a
+// rule matching the names which start with the path prefix, or path match, of
+// the port is returned. The prefix matches a whole label whether or not it
+// ends with a dot, so api and api. are the same.
+func domainRules(monitorPort *data.MonitorPort) []string {
+	if len(monitorPort.DomainRules) > 0 {
+		return monitorPort.DomainRules
+	}
+	prefix := monitorPort.PathPrefix
+	if len(prefix) == 0 {
+		prefix = monitorPort.PathMatch
+	}
+	if len(prefix) == 0 {
+		return nil
+	}
+	prefix = strings.TrimSuffix(prefix, ".")
+	return []string{fmt.Sprintf(`^%s\.(?P<%s>.+)$`, regexp.QuoteMeta(prefix), domainGroup)}
+}
+
+// normalizeDomain lower cases a domain and strips the trailing dot of a fully
+// qualified name and the leading label of a wildcard.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return strings.TrimPrefix(domain, "*.")
+}
+
+// matchDomainRule returns the base domain a rule captures from a name.
+func matchDomainRule(rule *regexp.Regexp, name string) string {
+	matches := rule.FindStringSubmatch(name)
+	if matches == nil {
+		return ""
+	}
+	return normalizeDomain(matches[rule.SubexpIndex(domainGroup)])
+}
+
+// certificateBaseDomain returns the base domain of the cluster serving a
+// certificate chain. Only the leaf certificate is considered. Each rule, in
+// order, is tried against the DNS names of the certificate in the order they
+// appear in it and the first match wins. The common name is only tried, again
+// with each rule in order, if no DNS name matches.
+func certificateBaseDomain(certs []*x509.Certificate, monitorPort *data.MonitorPort) string {
+	if len(certs) == 0 {
+		return ""
+	}
+	leaf := certs[0]
+
+	rules := []*regexp.Regexp{}
+	for _, rule := range domainRules(monitorPort) {
+		// rules are validated when the config is loaded
+		if compiled, err := compileDomainRule(rule); err == nil {
+			rules = append(rules, compiled)
+		}
+	}
+
+	for _, rule := range rules {
+		for _, name := range leaf.DNSNames {
+			if domain := matchDomainRule(rule, name); len(domain) > 0 {
+				return domain
+			}
+		}
+	}
+	if len(leaf.Subject.CommonName) > 0 {
+		for _, rule := range rules {
+			if domain := matchDomainRule(rule, leaf.Subject.CommonName); len(domain) > 0 {
+				return domain
+			}
+		}
+	}
+	return ""
+}
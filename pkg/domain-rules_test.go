@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestCertificateBaseDomain(t *testing.T) {
+	openshift := []*x509.Certificate{
+		{DNSNames: []string{"api-int.cluster-a.example.com", "api.cluster-a.example.com."}},
+		{DNSNames: []string{"api.ca.example.com"}},
+	}
+	ingress := []*x509.Certificate{
+		{DNSNames: []string{"*.apps.Cluster-B.example.com"}},
+	}
+	k3s := []*x509.Certificate{
+		{
+			Subject:  pkix.Name{CommonName: "k3s-server.k3s-1.lab.example.com"},
+			DNSNames: []string{"kubernetes", "kubernetes.default.svc.cluster.local", "localhost"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		certs    []*x509.Certificate
+		port     data.MonitorPort
+		expected string
+	}{
+		{
+			name:     "path match without a trailing dot",
+			certs:    openshift,
+			port:     data.MonitorPort{PathMatch: "api"},
+			expected: "cluster-a.example.com",
+		},
+		{
+			name:     "path match with a trailing dot",
+			certs:    openshift,
+			port:     data.MonitorPort{PathMatch: "api-int."},
+			expected: "cluster-a.example.com",
+		},
+		{
+			name:     "path prefix of a wildcard",
+			certs:    ingress,
+			port:     data.MonitorPort{PathPrefix: "*.apps"},
+			expected: "cluster-b.example.com",
+		},
+		{
+			name:     "no match",
+			certs:    ingress,
+			port:     data.MonitorPort{PathPrefix: "*.ingress"},
+			expected: "",
+		},
+		{
+			name:  "rules are tried in order",
+			certs: openshift,
+			port: data.MonitorPort{DomainRules: []string{
+				`^api\.(?P<domain>.+)$`,
+				`^api-int\.(?P<domain>.+)$`,
+			}},
+			expected: "cluster-a.example.com",
+		},
+		{
+			name:  "only the leaf certificate is used",
+			certs: openshift,
+			port: data.MonitorPort{DomainRules: []string{
+				`^(?P<domain>ca\.example\.com)$`,
+				`^api-int\.(?P<domain>.+)$`,
+			}},
+			expected: "cluster-a.example.com",
+		},
+		{
+			name:  "common name fallback",
+			certs: k3s,
+			port: data.MonitorPort{DomainRules: []string{
+				`^k3s-server\.(?P<domain>.+)$`,
+			}},
+			expected: "k3s-1.lab.example.com",
+		},
+		{
+			name:  "DNS names take precedence over the common name",
+			certs: k3s,
+			port: data.MonitorPort{DomainRules: []string{
+				`^k3s-server\.(?P<domain>.+)$`,
+				`^kubernetes\.default\.svc\.(?P<domain>.+)$`,
+			}},
+			expected: "cluster.local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if baseDomain := certificateBaseDomain(tt.certs, &tt.port); baseDomain != tt.expected {
+				t.Fatalf("expected %q, got %q", tt.expected, baseDomain)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Reasons of the events about scanned clusters.
+const (
+	EventReasonClusterReplaced     = "ClusterReplaced"
+	EventReasonCertificateExpiring = "CertificateExpiring"
+)
+
+// EventRecorder publishes events about the clusters found by scans, such as a
+// Kubernetes event recorder bound to an object.
+type EventRecorder interface {
+	Eventf(eventType, reason, messageFmt string, args ...interface{})
+}
+
+var (
+	eventRecorder     EventRecorder
+	eventRecorderLock sync.Mutex
+)
+
+// SetEventRecorder sets where events about scanned clusters are published in
+// addition to the log.
+func SetEventRecorder(recorder EventRecorder) {
+	eventRecorderLock.Lock()
+	defer eventRecorderLock.Unlock()
+	eventRecorder = recorder
+}
+
+// recordEvent logs an event and publishes it to the event recorder, if one is
+// set.
+func recordEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if eventType == corev1.EventTypeWarning {
+		logrus.Warnf("%s: %s", reason, message)
+	} else {
+		logrus.Infof("%s: %s", reason, message)
+	}
+
+	eventRecorderLock.Lock()
+	recorder := eventRecorder
+	eventRecorderLock.Unlock()
+	if recorder != nil {
+		recorder.Eventf(eventType, reason, "%s", message)
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// masterSocketTimeout bounds the time waiting for HAProxy to answer a reload.
+const masterSocketTimeout = 30 * time.Second
+
+// Reloader makes a running HAProxy load its configuration file again.
+type Reloader interface {
+	Reload() error
+}
+
+// PidfileReloader reloads HAProxy by sending SIGUSR2 to the master process
+// whose pid is held in a pidfile. HAProxy must run in master-worker mode.
+// Other proxies are reloaded with their own signal, such as SIGHUP for nginx.
+type PidfileReloader struct {
+	Path string
+	// Signal is sent instead of SIGUSR2 if it is set
+	Signal syscall.Signal
+}
+
+// Reload signals the process named by the pidfile.
+func (r *PidfileReloader) Reload() error {
+	pidRaw, err := os.ReadFile(r.Path)
+	if err != nil {
+		return fmt.Errorf("unable to read pidfile: %v", err)
+	}
+	// the pidfile of a master process holds only its own pid but older
+	// HAProxy versions write one line per process, the first is the master
+	fields := strings.Fields(string(pidRaw))
+	if len(fields) == 0 {
+		return fmt.Errorf("pidfile %s is empty", r.Path)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid pid in %s: %v", r.Path, err)
+	}
+	signal := r.Signal
+	if signal == 0 {
+		signal = syscall.SIGUSR2
+	}
+	if err := syscall.Kill(pid, signal); err != nil {
+		return fmt.Errorf("unable to signal process %d: %v", pid, err)
+	}
+	return nil
+}
+
+// MasterSocketReloader reloads HAProxy with the reload command of the master
+// CLI socket.
+type MasterSocketReloader struct {
+	Path string
+}
+
+// Reload sends the reload command and checks the response. HAProxy versions
+// before 2.7 close the connection without a response.
+func (r *MasterSocketReloader) Reload() error {
+	conn, err := net.DialTimeout("unix", r.Path, masterSocketTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the master socket: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(masterSocketTimeout))
+
+	if _, err := conn.Write([]byte("reload\n")); err != nil {
+		return fmt.Errorf("unable to send reload command: %v", err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return fmt.Errorf("unable to read reload response: %v", err)
+	}
+	if strings.Contains(string(response), "Success=0") {
+		return fmt.Errorf("HAProxy failed to reload: %s", strings.TrimSpace(string(response)))
+	}
+	return nil
+}
@@ -2,7 +2,14 @@ package pkg
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/netip"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
 	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
@@ -10,109 +17,411 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func createFrontend(name string, port *data.MonitorPort) *haproxy.Section {
+// createFrontend creates the frontend for a port. When ipv6 is set the frontend
+// also binds to the IPv6 wildcard address.
+func createFrontend(name string, port *data.MonitorPort, ipv6 bool) *haproxy.Frontend {
 	logrus.Infof("creating frontend %s", name)
 
-	return &haproxy.Section{
-		Type: haproxy.SectionFrontEnd,
+	frontend := haproxy.Frontend{
 		Name: name,
-		Attributes: []string{
-			"mode tcp",
-			fmt.Sprintf("bind 0.0.0.0:%d", (10000 + port.Port)),
-			"tcp-request content accept if { req_ssl_hello_type 1 }",
-			"tcp-request inspect-delay 5000",
+		Mode: haproxy.ModeTCP,
+		Binds: []haproxy.Bind{
+			{Address: "0.0.0.0", Port: listenerPortOffset + port.Port},
 		},
+		TCPRequestRules: []haproxy.TCPRequestRule{
+			{Action: "accept", Condition: &haproxy.Condition{Expression: "{ req_ssl_hello_type 1 }"}},
+		},
+		InspectDelay: 5 * time.Second,
+	}
+	if ipv6 {
+		frontend.AddBind(haproxy.Bind{Address: "::", Port: listenerPortOffset + port.Port, Options: []string{"v6only"}})
 	}
+	return &frontend
 }
 
-func createBackendSwitchingRule(baseDomain string, frontend *haproxy.Section, backend *haproxy.Section, port *data.MonitorPort) error {
-	logrus.Infof("creating backend switching rule %s", backend.Name)
+// ipv6Ports returns the ports which have at least one IPv6 target.
+func ipv6Ports(monitorConfig *data.MonitorConfig) map[int64]bool {
+	ports := map[int64]bool{}
+	for _, monitorRange := range monitorConfig.MonitorRanges {
+		for _, monitorPort := range monitorRange.MonitorPorts {
+			for _, target := range monitorPort.Targets {
+				if addr, err := netip.ParseAddr(target); err == nil && addr.Unmap().Is6() {
+					ports[monitorPort.Port] = true
+				}
+			}
+		}
+	}
+	return ports
+}
 
-	var rule string
-	if len(port.PathMatch) > 0 || len(port.PathPrefix) > 0 {
-		rule = fmt.Sprintf("if { req.ssl_sni -m end .%s }", baseDomain)
+// SNI routing modes.
+const (
+	// SNIRoutingRules routes with a use_backend rule for each port of each
+	// cluster
+	SNIRoutingRules = "rules"
+	// SNIRoutingMap routes with map files holding the names and backends of
+	// every cluster
+	SNIRoutingMap = "map"
+)
+
+const (
+	// HAProxyConfigFile is the name of the HAProxy configuration among the
+	// rendered files
+	HAProxyConfigFile = "haproxy.cfg"
+	// defaultMapDirectory is where HAProxy reads the map files from unless
+	// the config sets otherwise
+	defaultMapDirectory = "/etc/haproxy"
+)
+
+const (
+	// defaultServerSlots is how many servers are allocated to a backend at a
+	// time for the Runtime API unless the config sets otherwise
+	defaultServerSlots = 8
+	// serverSlotPrefix names the servers of backends updated through the
+	// Runtime API
+	serverSlotPrefix = "slot"
+)
+
+// apiLabel is the first label of the API host of a cluster, which is also
+// served as api-int.
+const apiLabel = "api"
+
+// backendSwitchingRule sends the connections to a frontend whose SNI matches a
+// port of a cluster to the backend of the port. A rule which matches neither
+// hosts nor a suffix matches every connection.
+type backendSwitchingRule struct {
+	backend string
+	// hosts are matched exactly
+	hosts []string
+	// suffix is matched against the end of the SNI
+	suffix string
+}
+
+// useBackend returns the use_backend rule of the rule.
+func (r *backendSwitchingRule) useBackend() haproxy.UseBackend {
+	useBackend := haproxy.UseBackend{Backend: r.backend}
+	switch {
+	case len(r.hosts) > 0:
+		useBackend.Condition = &haproxy.Condition{Expression: fmt.Sprintf("{ req.ssl_sni -i %s }", strings.Join(r.hosts, " "))}
+	case len(r.suffix) > 0:
+		useBackend.Condition = &haproxy.Condition{Expression: fmt.Sprintf("{ req.ssl_sni -m end -i %s }", r.suffix)}
 	}
+	return useBackend
+}
 
-	frontend.AppendAttribute(fmt.Sprintf("use_backend %s %s", backend.Name, rule))
-	return nil
+// String returns the use_backend line of the rule.
+func (r *backendSwitchingRule) String() string {
+	useBackend := r.useBackend()
+	return useBackend.String()
 }
 
-func createBackend(name string, port *data.MonitorPort) *haproxy.Section {
+// matchLength is the length of the longest name matched by the rule.
+func (r *backendSwitchingRule) matchLength() int {
+	length := len(r.suffix)
+	for _, host := range r.hosts {
+		if len(host) > length {
+			length = len(host)
+		}
+	}
+	return length
+}
+
+// backendSwitchingRuleLess orders rules so the most specific match is tried
+// first: longest match first, exact hosts before suffixes and then by backend.
+// Rules which match every connection are last.
+func backendSwitchingRuleLess(a, b *backendSwitchingRule) bool {
+	if a.matchLength() != b.matchLength() {
+		return a.matchLength() > b.matchLength()
+	}
+	if (len(a.hosts) > 0) != (len(b.hosts) > 0) {
+		return len(a.hosts) > 0
+	}
+	return a.backend < b.backend
+}
+
+// appendBackendSwitchingRules adds the rules to a frontend, most specific
+// first.
+func appendBackendSwitchingRules(frontend *haproxy.Frontend, rules []*backendSwitchingRule) {
+	sorted := append([]*backendSwitchingRule{}, rules...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return backendSwitchingRuleLess(sorted[a], sorted[b])
+	})
+	for _, rule := range sorted {
+		frontend.AddUseBackend(rule.useBackend())
+	}
+}
+
+// createBackend creates the backend for a port of a cluster. Servers are
+// ordered by address whatever order the targets were found in.
+func createBackend(name string, port *data.MonitorPort) *haproxy.Backend {
 	logrus.Infof("creating backend %s", name)
 
-	backend := haproxy.Section{
-		Type: haproxy.SectionBackEnd,
+	backend := haproxy.Backend{
 		Name: name,
-		Attributes: []string{
-			"mode tcp",
-		},
+		Mode: haproxy.ModeTCP,
 	}
 
-	for _, target := range port.Targets {
-		port := port.Port
-		serverName := fmt.Sprintf("%s-%d", target, port)
-		server := fmt.Sprintf("server %s %s:%d check verify none", serverName, target, port)
-		backend.AppendAttribute(server)
+	targets := append([]string{}, port.Targets...)
+	sort.SliceStable(targets, func(a, b int) bool {
+		return util.AddressLess(targets[a], targets[b])
+	})
+	for _, target := range targets {
+		backend.AddServer(haproxy.Server{
+			Name:    fmt.Sprintf("%s-%d", target, port.Port),
+			Address: target,
+			Port:    port.Port,
+			Check:   true,
+			Options: []string{"verify none"},
+		})
 	}
 	return &backend
 }
 
-func BuildDynamicConfiguration(monitorConfig *data.MonitorConfig) (string, error) {
-	sections := []*haproxy.Section{}
+// serverSlots is how many servers are allocated to a backend at a time, or 0
+// if targets are not updated through the Runtime API.
+func serverSlots(monitorConfig *data.MonitorConfig) int {
+	if len(monitorConfig.RuntimeAPI.Address) == 0 {
+		return 0
+	}
+	if monitorConfig.RuntimeAPI.ServerSlots > 0 {
+		return monitorConfig.RuntimeAPI.ServerSlots
+	}
+	return defaultServerSlots
+}
 
-	frontEnds := map[string]*haproxy.Section{}
+// allocateServerSlots numbers the servers of a backend as slots and adds a
+// server-template of disabled slots after them, so the Runtime API can move
+// targets in and out of the backend without a reload. Slots are allocated in
+// multiples of slots, leaving at least one free.
+func allocateServerSlots(backend *haproxy.Backend, port int64, slots int) {
+	capacity := slots * (len(backend.Servers)/slots + 1)
+	for idx := range backend.Servers {
+		backend.Servers[idx].Name = serverSlotPrefix + strconv.Itoa(idx+1)
+	}
+	backend.ServerTemplates = []haproxy.ServerTemplate{{
+		Prefix:  serverSlotPrefix,
+		First:   len(backend.Servers) + 1,
+		Last:    capacity,
+		Address: "0.0.0.0",
+		Port:    port,
+		Check:   true,
+		Options: []string{"verify none", "disabled"},
+	}}
+}
 
-	var frontEnd *haproxy.Section
+// withoutServerTargets returns a copy of the config whose backends only keep
+// how many server slots they have, as the Runtime API moves targets between
+// slots without a reload.
+func withoutServerTargets(config *haproxy.Config) *haproxy.Config {
+	copied := *config
+	copied.Backends = []*haproxy.Backend{}
+	for _, backend := range config.Backends {
+		slotted := *backend
+		capacity := len(backend.Servers)
+		for _, template := range backend.ServerTemplates {
+			capacity += template.Last - template.First + 1
+		}
+		slotted.Servers = nil
+		slotted.ServerTemplates = []haproxy.ServerTemplate{{Prefix: serverSlotPrefix, First: 1, Last: capacity}}
+		copied.Backends = append(copied.Backends, &slotted)
+	}
+	return &copied
+}
 
-	for _, monitorRange := range monitorConfig.MonitorRanges {
-		for _, monitorPort := range monitorRange.MonitorPorts {
-			if len(monitorPort.Targets) == 0 || len(monitorRange.BaseDomain) == 0 {
-				continue
+// appendBackendSwitchingMaps routes a frontend with map files rather than a
+// rule for each backend. Hosts matched exactly are looked up in one map and
+// suffixes, most specific first, in another. Rules which match every
+// connection are added as they are. The content of the maps is returned by
+// file name.
+func appendBackendSwitchingMaps(frontend *haproxy.Frontend, rules []*backendSwitchingRule, directory string) map[string]string {
+	sorted := append([]*backendSwitchingRule{}, rules...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return backendSwitchingRuleLess(sorted[a], sorted[b])
+	})
+
+	hosts, suffixes := &bytes.Buffer{}, &bytes.Buffer{}
+	unconditional := []*backendSwitchingRule{}
+	for _, rule := range sorted {
+		switch {
+		case len(rule.hosts) > 0:
+			for _, host := range rule.hosts {
+				fmt.Fprintf(hosts, "%s %s\n", strings.ToLower(host), rule.backend)
 			}
-			name := fmt.Sprintf("%s-%d", monitorRange.BaseDomain, monitorPort.Port)
-			frontendName := fmt.Sprintf("dynaconfig-fe-%d", monitorPort.Port)
+		case len(rule.suffix) > 0:
+			fmt.Fprintf(suffixes, "%s %s\n", strings.ToLower(rule.suffix), rule.backend)
+		default:
+			unconditional = append(unconditional, rule)
+		}
+	}
 
-			var exists bool
+	maps := map[string]string{}
+	appendMap := func(name, converter string, content *bytes.Buffer) {
+		if content.Len() == 0 {
+			return
+		}
+		maps[name] = content.String()
+		lookup := fmt.Sprintf("req.ssl_sni,lower,%s(%s)", converter, path.Join(directory, name))
+		frontend.AddUseBackend(haproxy.UseBackend{
+			Backend:   fmt.Sprintf("%%[%s]", lookup),
+			Condition: &haproxy.Condition{Expression: fmt.Sprintf("{ %s -m found }", lookup)},
+		})
+	}
+	appendMap(frontend.Name+"-hosts.map", "map_str", hosts)
+	appendMap(frontend.Name+".map", "map_end", suffixes)
+	for _, rule := range unconditional {
+		frontend.AddUseBackend(rule.useBackend())
+	}
+	return maps
+}
 
-			if frontEnd, exists = frontEnds[frontendName]; !exists {
-				frontEnd = createFrontend(frontendName, &monitorPort)
-				frontEnds[frontendName] = frontEnd
-			}
+// BuildDynamicConfiguration builds a frontend for each monitored port and a
+// backend for each port of each cluster found in the monitor config. In the
+// map SNI routing mode the map files are only returned by
+// BuildTargetHAProxyFiles.
+func BuildDynamicConfiguration(monitorConfig *data.MonitorConfig) (string, error) {
+	content, _, err := buildDynamicConfiguration(monitorConfig)
+	return content, err
+}
 
-			backEnd := createBackend(name, &monitorPort)
+// buildDynamicConfiguration builds the dynamic configuration and the map files
+// it references, keyed by file name.
+func buildDynamicConfiguration(monitorConfig *data.MonitorConfig) (string, map[string]string, error) {
+	config, maps, err := BuildDynamicModel(monitorConfig)
+	if err != nil {
+		return "", nil, err
+	}
+	return config.Serialize(nil).String(), maps, nil
+}
+
+// BuildDynamicModel builds the frontends and backends of the dynamic
+// configuration from the routing table as a model which may be inspected or
+// changed before it is serialized, along with the map files it references
+// keyed by file name. Frontends and backends are ordered by name, so the same
+// clusters always build the same model whatever order they were found in.
+func BuildDynamicModel(monitorConfig *data.MonitorConfig) (*haproxy.Config, map[string]string, error) {
+	config := &haproxy.Config{}
+	maps := map[string]string{}
+
+	slots := serverSlots(monitorConfig)
 
-			err := createBackendSwitchingRule(monitorRange.BaseDomain, frontEnd, backEnd, &monitorPort)
-			if err != nil {
-				return "", fmt.Errorf("unable to create backend switching rules: %w", err)
+	for _, listener := range BuildRoutingTable(monitorConfig).Listeners {
+		frontEnd := createFrontend(listener.Name, &data.MonitorPort{Port: listener.Port}, listener.IPv6)
+		rules := []*backendSwitchingRule{}
+		for _, route := range listener.Routes {
+			backEnd := createBackend(route.Name, &data.MonitorPort{Port: route.Port, Targets: route.Targets})
+			if slots > 0 {
+				allocateServerSlots(backEnd, route.Port, slots)
 			}
+			logrus.Infof("creating backend switching rule %s", backEnd.Name)
+			rules = append(rules, route.rule())
 
-			sections = append(sections, backEnd)
+			config.Backends = append(config.Backends, backEnd)
 		}
+		if monitorConfig.SNIRouting.Mode == SNIRoutingMap {
+			directory := monitorConfig.SNIRouting.MapDirectory
+			if len(directory) == 0 {
+				directory = defaultMapDirectory
+			}
+			for name, content := range appendBackendSwitchingMaps(frontEnd, rules, directory) {
+				maps[name] = content
+			}
+		} else {
+			appendBackendSwitchingRules(frontEnd, rules)
+		}
+		config.Frontends = append(config.Frontends, frontEnd)
 	}
-	for _, frontEnd := range frontEnds {
-		sections = append(sections, frontEnd)
-	}
+	sort.SliceStable(config.Backends, func(a, b int) bool {
+		return config.Backends[a].Name < config.Backends[b].Name
+	})
+
+	return config, maps, nil
+}
 
-	buf := &bytes.Buffer{}
-	for _, section := range sections {
-		buf = section.Serialize(buf)
+func BuildTargetHAProxyConfig(monitorConfig *data.MonitorConfig) (string, string, error) {
+	files, hash, err := BuildTargetHAProxyFiles(monitorConfig)
+	if err != nil {
+		return "", "", err
 	}
+	return files[HAProxyConfigFile], hash, nil
+}
 
-	return buf.String(), nil
+// BuildTargetHAProxyFiles builds the HAProxy configuration and any map files
+// it references, keyed by file name. The hash is a ConfigurationHash of what
+// is built. When targets are updated through the Runtime API the servers of
+// backends are left out of the hash, so only a change to the frontends or
+// backends themselves changes it.
+func BuildTargetHAProxyFiles(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	files, hash, _, err := BuildTargetHAProxy(monitorConfig)
+	return files, hash, err
 }
 
-func BuildTargetHAProxyConfig(monitorConfig *data.MonitorConfig) (string, string, error) {
+// BuildTargetHAProxy builds the files and their hash along with the model of
+// the dynamic configuration.
+func BuildTargetHAProxy(monitorConfig *data.MonitorConfig) (map[string]string, string, *haproxy.Config, error) {
+	config, maps, err := BuildDynamicModel(monitorConfig)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("unable to build the dynamic configuration: %v", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, "", nil, err
+	}
+
 	buffer := bytes.Buffer{}
 	buffer.WriteString(monitorConfig.HaproxyHeader)
+	config.Serialize(&buffer)
+
+	files := map[string]string{HAProxyConfigFile: buffer.String()}
+	for name, content := range maps {
+		files[name] = content
+	}
 
-	dynamicConfig, err := BuildDynamicConfiguration(monitorConfig)
+	// HAProxy would refuse to start, so the files aren't returned to be
+	// published
+	problems := haproxy.Lint(monitorConfig.HaproxyHeader, config, maps)
+	for _, problem := range problems.Warnings() {
+		logrus.Warnf("HAProxy configuration %v", problem)
+	}
+	if err := problems.Err(); err != nil {
+		return nil, "", nil, err
+	}
+	hashed := config
+	if serverSlots(monitorConfig) > 0 {
+		hashed = withoutServerTargets(config)
+	}
+	hash, err := ConfigurationHash(monitorConfig.HaproxyHeader, hashed, maps)
 	if err != nil {
-		return "", "", fmt.Errorf("unable to build the dynamic configuration: %v", err)
+		return nil, "", nil, err
 	}
+	return files, hash, config, nil
+}
 
-	buffer.WriteString(dynamicConfig)
+// ConfigurationHash hashes a normalized form of the routing table: the header
+// without blank lines, comments or trailing whitespace, the model and the map
+// files. Changes which don't change how connections are routed, such as
+// reordered clusters or targets or a reworded comment, don't change the hash.
+func ConfigurationHash(header string, config *haproxy.Config, maps map[string]string) (string, error) {
+	// maps are encoded with their keys in order
+	normalized, err := json.Marshal(struct {
+		Header []string
+		Config *haproxy.Config
+		Maps   map[string]string
+	}{normalizedHeader(header), config, maps})
+	if err != nil {
+		return "", fmt.Errorf("unable to normalize the configuration: %v", err)
+	}
+	return util.GenerateSHA512Hash(normalized), nil
+}
 
-	outStr := buffer.String()
-	hash := util.GenerateSHA512Hash(buffer.Bytes())
-	return outStr, hash, nil
+// normalizedHeader returns the lines of the header without blank lines,
+// comments or trailing whitespace.
+func normalizedHeader(header string) []string {
+	headerLines := []string{}
+	for _, line := range strings.Split(header, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		headerLines = append(headerLines, line)
+	}
+	return headerLines
 }
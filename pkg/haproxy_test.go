@@ -6,6 +6,7 @@ import (
 
 	"github.com/andreyvit/diff"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
 )
 
 const (
@@ -30,7 +31,22 @@ frontend frontend-1
   bind 0.0.0.0:10443
   tcp-request content accept if { req_ssl_hello_type 1 }
   tcp-request inspect-delay 5000
-  use_backend backend-1 if { req.ssl_sni -m end .example.com }
+  use_backend backend-1 if { req.ssl_sni -m end -i .apps.example.com }
+`
+
+	goodIPv6Backend = `
+backend backend-1
+  mode tcp
+  server 2001:db8::4-443 [2001:db8::4]:443 check verify none
+`
+
+	goodIPv6Frontend = `
+frontend frontend-1
+  mode tcp
+  bind 0.0.0.0:10443
+  bind [::]:10443 v6only
+  tcp-request content accept if { req_ssl_hello_type 1 }
+  tcp-request inspect-delay 5000
 `
 
 	goodAPIFrontEnd = `
@@ -39,7 +55,7 @@ frontend frontend-1
   bind 0.0.0.0:16443
   tcp-request content accept if { req_ssl_hello_type 1 }
   tcp-request inspect-delay 5000
-  use_backend backend-1 if { req.ssl_sni -m end .example.com }
+  use_backend backend-1 if { req.ssl_sni -i api.example.com api-int.example.com }
 `
 )
 
@@ -73,8 +89,23 @@ func TestCreateBackend(t *testing.T) {
 	expectMatch(t, sectionStr, goodBackend)
 }
 
+func TestCreateIPv6BackendAndFrontend(t *testing.T) {
+	port := data.MonitorPort{
+		Port:       443,
+		Targets:    []string{"2001:db8::4"},
+		PathPrefix: "*.apps",
+	}
+	monitorConfig := data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{{MonitorPorts: []data.MonitorPort{port}}},
+	}
+
+	expectMatch(t, createBackend("backend-1", &port).Serialize(nil).String(), goodIPv6Backend)
+	frontend := createFrontend("frontend-1", &port, ipv6Ports(&monitorConfig)[port.Port])
+	expectMatch(t, frontend.Serialize(nil).String(), goodIPv6Frontend)
+}
+
 func TestCreateFrontend(t *testing.T) {
-	section := createFrontend("frontend-1", &appsPort)
+	section := createFrontend("frontend-1", &appsPort, false)
 	sectionBytes := section.Serialize(nil)
 	sectionStr := sectionBytes.String()
 
@@ -102,17 +133,275 @@ func TestCreateBackendRules(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			backend := createBackend("backend-1", &tt.port)
-			frontend := createFrontend("frontend-1", &tt.port)
+			frontend := createFrontend("frontend-1", &tt.port, false)
 
-			if err := createBackendSwitchingRule(baseDomain, frontend, backend, &tt.port); err != nil {
-				t.Fatal(err)
-			}
+			route := Route{Name: backend.Name}
+			route.Hosts, route.Suffix = routeMatch(baseDomain, &tt.port)
+			appendBackendSwitchingRules(frontend, []*backendSwitchingRule{route.rule()})
 			expectMatch(t, frontend.Serialize(nil).String(), tt.expected)
 		})
 	}
 
 }
 
+func TestBackendSwitchingRuleOrder(t *testing.T) {
+	cluster := func(baseDomain string) data.MonitorRange {
+		return data.MonitorRange{
+			BaseDomain: baseDomain,
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api.", Targets: []string{"192.168.1.4"}},
+				{Port: 443, PathPrefix: "*.apps.", Targets: []string{"192.168.1.5"}},
+			},
+		}
+	}
+	monitorConfig := &data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{cluster("a.example.com"), cluster("b.a.example.com"), cluster("c.example.com")},
+	}
+
+	config, err := BuildDynamicConfiguration(monitorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"use_backend b.a.example.com-443 if { req.ssl_sni -m end -i .apps.b.a.example.com }",
+		"use_backend a.example.com-443 if { req.ssl_sni -m end -i .apps.a.example.com }",
+		"use_backend c.example.com-443 if { req.ssl_sni -m end -i .apps.c.example.com }",
+		"use_backend b.a.example.com-6443 if { req.ssl_sni -i api.b.a.example.com api-int.b.a.example.com }",
+		"use_backend a.example.com-6443 if { req.ssl_sni -i api.a.example.com api-int.a.example.com }",
+		"use_backend c.example.com-6443 if { req.ssl_sni -i api.c.example.com api-int.c.example.com }",
+	}
+	rules := []string{}
+	for _, line := range strings.Split(config, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "use_backend") {
+			rules = append(rules, strings.TrimSpace(line))
+		}
+	}
+	expectMatch(t, strings.Join(rules, "\n"), strings.Join(expected, "\n"))
+
+	// the output doesn't depend on the order the clusters are found in
+	monitorConfig.MonitorRanges = []data.MonitorRange{cluster("c.example.com"), cluster("b.a.example.com"), cluster("a.example.com")}
+	reordered, err := BuildDynamicConfiguration(monitorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range expected {
+		if strings.Index(reordered, rule) < 0 {
+			t.Fatalf("expected %s in:\n%s", rule, reordered)
+		}
+	}
+	if strings.Index(reordered, expected[0]) > strings.Index(reordered, expected[1]) ||
+		strings.Index(reordered, expected[3]) > strings.Index(reordered, expected[4]) {
+		t.Fatalf("expected the longest names to be matched first:\n%s", reordered)
+	}
+}
+
+func TestBuildTargetHAProxyFilesMaps(t *testing.T) {
+	cluster := func(baseDomain, target string) data.MonitorRange {
+		return data.MonitorRange{
+			BaseDomain: baseDomain,
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: []string{target}},
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{target}},
+			},
+		}
+	}
+	monitorConfig := &data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{cluster("a.example.com", "192.168.1.4"), cluster("b.a.example.com", "192.168.1.5")},
+		SNIRouting:    data.SNIRoutingConfig{Mode: SNIRoutingMap, MapDirectory: "/usr/local/etc/haproxy"},
+	}
+
+	files, hash, err := BuildTargetHAProxyFiles(monitorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"dynaconfig-fe-443.map": ".apps.b.a.example.com b.a.example.com-443\n" +
+			".apps.a.example.com a.example.com-443\n",
+		"dynaconfig-fe-6443-hosts.map": "api.b.a.example.com b.a.example.com-6443\n" +
+			"api-int.b.a.example.com b.a.example.com-6443\n" +
+			"api.a.example.com a.example.com-6443\n" +
+			"api-int.a.example.com a.example.com-6443\n",
+	}
+	for name, content := range expected {
+		expectMatch(t, files[name], content)
+	}
+	if len(files) != len(expected)+1 {
+		t.Fatalf("expected %d files, got %d", len(expected)+1, len(files))
+	}
+
+	config := files[HAProxyConfigFile]
+	for _, rule := range []string{
+		"use_backend %[req.ssl_sni,lower,map_end(/usr/local/etc/haproxy/dynaconfig-fe-443.map)] if { req.ssl_sni,lower,map_end(/usr/local/etc/haproxy/dynaconfig-fe-443.map) -m found }",
+		"use_backend %[req.ssl_sni,lower,map_str(/usr/local/etc/haproxy/dynaconfig-fe-6443-hosts.map)] if { req.ssl_sni,lower,map_str(/usr/local/etc/haproxy/dynaconfig-fe-6443-hosts.map) -m found }",
+	} {
+		if !strings.Contains(config, rule+"\n") {
+			t.Fatalf("expected %s in:\n%s", rule, config)
+		}
+	}
+	if strings.Count(config, "use_backend") != 2 {
+		t.Fatalf("expected a single rule for each frontend:\n%s", config)
+	}
+
+	// a change to a map alone changes the hash
+	monitorConfig.MonitorRanges[1].MonitorPorts[1].PathPrefix = "*.ingress"
+	_, changedHash, err := BuildTargetHAProxyFiles(monitorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changedHash == hash {
+		t.Fatal("expected the hash to cover the map files")
+	}
+}
+
+func TestBuildDynamicModel(t *testing.T) {
+	monitorConfig := &data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{
+			{
+				BaseDomain: "a.example.com",
+				MonitorPorts: []data.MonitorPort{
+					{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.4", "192.168.1.5"}},
+					{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.4"}},
+				},
+			},
+		},
+	}
+
+	config, _, err := BuildDynamicModel(monitorConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Frontends) != 2 || config.Frontends[0].Name != "dynaconfig-fe-443" {
+		t.Fatalf("expected frontends ordered by name, got %d", len(config.Frontends))
+	}
+	backend := config.Backend("a.example.com-6443")
+	if backend == nil || len(backend.Servers) != 2 {
+		t.Fatal("expected a backend with a server for each target")
+	}
+
+	// the model may be changed before it is serialized
+	backend.Balance = "roundrobin"
+	backend.Servers[1].Options = append(backend.Servers[1].Options, "backup")
+	serialized := config.Serialize(nil).String()
+	for _, line := range []string{
+		"\nbackend a.example.com-6443\n  mode tcp\n  balance roundrobin\n",
+		"  server 192.168.1.5-6443 192.168.1.5:6443 check verify none backup\n",
+	} {
+		if !strings.Contains(serialized, line) {
+			t.Fatalf("expected %q in:\n%s", line, serialized)
+		}
+	}
+	if serialized != config.Serialize(nil).String() {
+		t.Fatal("expected serialization to be deterministic")
+	}
+
+	// rules routing to a missing backend are found
+	frontend := config.Frontend("dynaconfig-fe-6443")
+	frontend.AddUseBackend(haproxy.UseBackend{Backend: "missing"})
+	frontend.AddBind(haproxy.Bind{Address: "0.0.0.0", Port: 70000})
+	err = config.Validate()
+	if err == nil {
+		t.Fatal("expected the changed model to be invalid")
+	}
+	for _, problem := range []string{"routes to unknown backend missing", "binds to invalid port 70000"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Fatalf("expected %q in %v", problem, err)
+		}
+	}
+
+	// an invalid model isn't built into files
+	_, _, err = BuildTargetHAProxyFiles(&data.MonitorConfig{MonitorRanges: []data.MonitorRange{{
+		BaseDomain:   "a.example.com",
+		MonitorPorts: []data.MonitorPort{{Port: 60000, PathMatch: "api", Targets: []string{"192.168.1.4"}}},
+	}}})
+	if err == nil || !strings.Contains(err.Error(), "binds to invalid port 70000") {
+		t.Fatalf("expected the invalid model to be refused, got %v", err)
+	}
+}
+
+func TestBuildTargetHAProxyFilesDeterministic(t *testing.T) {
+	cluster := func(baseDomain string, targets ...string) data.MonitorRange {
+		return data.MonitorRange{
+			BaseDomain: baseDomain,
+			MonitorPorts: []data.MonitorPort{
+				{Port: 6443, PathMatch: "api", Targets: targets},
+				{Port: 443, PathPrefix: "*.apps", Targets: targets},
+			},
+		}
+	}
+	build := func(header string, ranges ...data.MonitorRange) (string, string) {
+		files, hash, err := BuildTargetHAProxyFiles(&data.MonitorConfig{HaproxyHeader: header, MonitorRanges: ranges})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files[HAProxyConfigFile], hash
+	}
+
+	header := "global\n  maxconn 100\n"
+	config, hash := build(header, cluster("a.example.com", "192.168.1.10", "192.168.1.4"), cluster("b.example.com", "192.168.1.5"))
+
+	// the same clusters found in another order render the same configuration
+	reordered, reorderedHash := build(header, cluster("b.example.com", "192.168.1.5"), cluster("a.example.com", "192.168.1.4", "192.168.1.10"))
+	expectMatch(t, reordered, config)
+	if reorderedHash != hash {
+		t.Fatal("expected reordered clusters to have the same hash")
+	}
+	if strings.Index(config, "backend a.example.com-443") > strings.Index(config, "backend b.example.com-443") ||
+		strings.Index(config, "server 192.168.1.4-443") > strings.Index(config, "server 192.168.1.10-443") {
+		t.Fatalf("expected backends ordered by name and servers by address:\n%s", config)
+	}
+
+	// comments and blank lines in the header don't change the hash
+	if _, commentedHash := build("# maintained by the lab team\nglobal  \n\n  maxconn 100\n", cluster("a.example.com", "192.168.1.10", "192.168.1.4"), cluster("b.example.com", "192.168.1.5")); commentedHash != hash {
+		t.Fatal("expected header comments not to change the hash")
+	}
+
+	// a change to the routing table does
+	if _, changedHash := build(header, cluster("a.example.com", "192.168.1.10", "192.168.1.4"), cluster("b.example.com", "192.168.1.6")); changedHash == hash {
+		t.Fatal("expected a changed target to change the hash")
+	}
+	if _, changedHash := build("global\n  maxconn 200\n", cluster("a.example.com", "192.168.1.10", "192.168.1.4"), cluster("b.example.com", "192.168.1.5")); changedHash == hash {
+		t.Fatal("expected a changed header to change the hash")
+	}
+}
+
+func TestBuildTargetHAProxyFilesServerSlots(t *testing.T) {
+	build := func(targets ...string) (string, string) {
+		files, hash, err := BuildTargetHAProxyFiles(&data.MonitorConfig{
+			RuntimeAPI: data.RuntimeAPIConfig{Address: "/var/run/haproxy.sock", ServerSlots: 2},
+			MonitorRanges: []data.MonitorRange{{
+				BaseDomain:   "cluster.example.com",
+				MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api", Targets: targets}},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files[HAProxyConfigFile], hash
+	}
+
+	config, hash := build("192.168.1.4", "192.168.1.5", "192.168.1.6")
+	for _, line := range []string{
+		"  server slot1 192.168.1.4:6443 check verify none\n",
+		"  server slot3 192.168.1.6:6443 check verify none\n",
+		"  server-template slot 4-4 0.0.0.0:6443 check verify none disabled\n",
+	} {
+		if !strings.Contains(config, line) {
+			t.Fatalf("expected %q in:\n%s", line, config)
+		}
+	}
+
+	// targets moved within the slots of a backend don't need a reload
+	if _, movedHash := build("192.168.1.4", "192.168.1.7"); movedHash != hash {
+		t.Fatal("expected targets within the allocated slots not to change the hash")
+	}
+	if _, grownHash := build("192.168.1.4", "192.168.1.5", "192.168.1.6", "192.168.1.7"); grownHash == hash {
+		t.Fatal("expected more slots to change the hash")
+	}
+}
+
 /*func TestBuildDynamicConfiguration(t *testing.T) {
 	config, err := BuildDynamicConfiguration(&goodMonitorConfig.MonitorConfig)
 	if err != nil {
@@ -133,3 +422,101 @@ func TestCreateBackendRules(t *testing.T) {
 	t.Log("checking config")
 	expectMatch(t, config, goodTargetConfig)
 }*/
+
+func TestLintHAProxyConfig(t *testing.T) {
+	cluster := data.MonitorRange{
+		BaseDomain:   "cluster.example.com",
+		MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.4"}}},
+	}
+	tests := []struct {
+		name     string
+		header   string
+		errors   []string
+		warnings []string
+	}{
+		{
+			name:   "clean header",
+			header: "global\n  maxconn 100\ndefaults\n  mode tcp\n  log-format \"%ci # %[ssl_fc_sni]\"\nfrontend stats\n  bind :9000\n  default_backend stats\nbackend stats\n  server local 127.0.0.1:9001\n",
+		},
+		{
+			name:   "duplicate sections and servers",
+			header: "listen stats\n  bind :9000\n  server a 127.0.0.1:9001\n  server a 127.0.0.1:9002\nbackend stats\n  server b 127.0.0.1:9001\nfrontend other\n  bind :9100\n  use_backend stats\nbackend other\n  server c 127.0.0.1:9001\n",
+			errors: []string{
+				"line 4: error: listen stats declares server a again, first at line 3",
+				"line 5: error: backend stats has the same name as the listen at line 1",
+			},
+			warnings: []string{"line 10: warning: backend other is not routed to"},
+		},
+		{
+			name:     "missing and empty backends",
+			header:   "frontend stats\n  bind :9000\n  use_backend missing if { src 10.0.0.0/8 }\n  default_backend empty\nbackend empty\n",
+			errors:   []string{"line 3: error: frontend stats routes to unknown backend missing"},
+			warnings: []string{"line 5: warning: backend empty has no servers"},
+		},
+		{
+			name:   "bind collisions with the dynamic frontends",
+			header: "frontend api\n  bind *:16443\nfrontend api-v6\n  bind [::]:16000-17000 v6only\nfrontend api-dual\n  bind ipv6@:::16443\n  bind /var/run/api.sock\n",
+			errors: []string{
+				"line 6: error: frontend api-dual binds to ipv6@:::16443 which is already bound by frontend api at line 2",
+				"line 6: error: frontend api-dual binds to ipv6@:::16443 which is already bound by frontend api-v6 at line 4",
+				"line 2: error: frontend api binds to *:16443 which is also bound by frontend dynaconfig-fe-6443 of the dynamic configuration",
+			},
+		},
+		{
+			name:   "sections named like the dynamic configuration",
+			header: "frontend dynaconfig-fe-6443\n  bind :9000\n  default_backend cluster.example.com-6443\nlisten cluster.example.com-6443\n  bind :9100\n  server a 127.0.0.1:9001\n",
+			errors: []string{
+				"line 1: error: frontend dynaconfig-fe-6443 has the same name as a frontend of the dynamic configuration",
+				"line 4: error: listen cluster.example.com-6443 has the same name as a backend of the dynamic configuration",
+			},
+		},
+		{
+			name:   "invalid names",
+			header: "backend bad/name\n  server \"bad server\" 127.0.0.1:9001\n  server-template slot 0-2 127.0.0.1:9001\n  dispatch 127.0.0.1:9001\n  maxconn 10\n",
+			errors: []string{
+				"line 1: error: backend has an invalid name \"bad/name\"",
+				"line 2: error: backend bad/name has a server with an invalid name \"bad server\"",
+				"line 3: error: backend bad/name server template slot has invalid range 0-2",
+			},
+			warnings: []string{"backend bad/name is not routed to"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			monitorConfig := &data.MonitorConfig{HaproxyHeader: tt.header, MonitorRanges: []data.MonitorRange{cluster}}
+			model, maps, err := BuildDynamicModel(monitorConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			problems := haproxy.Lint(tt.header, model, maps)
+
+			lines := func(problems haproxy.LintProblems) string {
+				lines := []string{}
+				for _, problem := range problems {
+					lines = append(lines, problem.String())
+				}
+				return strings.Join(lines, "\n")
+			}
+			for _, expected := range tt.warnings {
+				if !strings.Contains(lines(problems.Warnings()), expected) {
+					t.Fatalf("expected warning %q, got:\n%s", expected, lines(problems))
+				}
+			}
+			_, _, err = BuildTargetHAProxyFiles(monitorConfig)
+			if len(tt.errors) == 0 {
+				if err != nil {
+					t.Fatalf("expected the configuration to pass the linter, got %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected the configuration to be refused")
+			}
+			for _, expected := range tt.errors {
+				if !strings.Contains(err.Error(), expected) {
+					t.Fatalf("expected error %q, got %v", expected, err)
+				}
+			}
+		})
+	}
+}
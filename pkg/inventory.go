@@ -0,0 +1,473 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// inventoryForgetAfter is the number of consecutive failed probes after which
+// an address is dropped from the inventory.
+const inventoryForgetAfter = 5
+
+// InventoryEntry is what is known about a port of an address which has
+// responded to a probe.
+type InventoryEntry struct {
+	// Range identifies the monitor range the address belongs to
+	Range      string `json:"range"`
+	Address    string `json:"address"`
+	Port       int64  `json:"port"`
+	BaseDomain string `json:"baseDomain,omitempty"`
+	// Fingerprint is the SHA-256 fingerprint of the certificate served
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Issuer is the issuer of the certificate served
+	Issuer string `json:"issuer,omitempty"`
+	// NotAfter is when the certificate served expires
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	// SelfSigned is set if the certificate chain served ends in a self-signed
+	// certificate
+	SelfSigned           bool      `json:"selfSigned,omitempty"`
+	FirstSeen            time.Time `json:"firstSeen"`
+	LastSeen             time.Time `json:"lastSeen"`
+	ConsecutiveFailures  int       `json:"consecutiveFailures"`
+	ConsecutiveSuccesses int       `json:"consecutiveSuccesses"`
+	// Routed is set once the address has passed the rise threshold of the
+	// port and cleared once it passes the fall threshold
+	Routed bool `json:"routed"`
+	// RoutedChanged is when Routed last changed
+	RoutedChanged time.Time `json:"routedChanged,omitempty"`
+}
+
+// damping is the rise and fall thresholds and hold time of a port.
+type damping struct {
+	rise int
+	fall int
+	hold time.Duration
+}
+
+func portDamping(monitorPort *data.MonitorPort) damping {
+	d := damping{
+		rise: monitorPort.Rise,
+		fall: monitorPort.Fall,
+		hold: time.Duration(monitorPort.HoldTime) * time.Millisecond,
+	}
+	if d.rise < 1 {
+		d.rise = 1
+	}
+	if d.fall < 1 {
+		d.fall = 1
+	}
+	return d
+}
+
+// updateRouted applies the damping to the latest probe results of the entry,
+// returning true if the address was added to or removed from the routed
+// targets.
+func (e *InventoryEntry) updateRouted(d damping, now time.Time) bool {
+	held := now.Sub(e.RoutedChanged) >= d.hold
+	switch {
+	case !e.Routed && e.ConsecutiveSuccesses >= d.rise && held:
+		e.Routed = true
+	case e.Routed && e.ConsecutiveFailures >= d.fall && held:
+		e.Routed = false
+	default:
+		return false
+	}
+	e.RoutedChanged = now
+	return true
+}
+
+// replacedBy reports whether a port which served a certificate is now served
+// by another cluster. A new certificate from the same issuer for the same base
+// domain is a rotation, not a replacement. It is checked on every probe which
+// found a certificate, including those whose base domain failed the domain
+// policy, so the old route is torn down whether or not the new cluster is
+// routed.
+func (e *InventoryEntry) replacedBy(status *PortStatus) bool {
+	if len(e.Fingerprint) == 0 || len(status.Fingerprint) == 0 || e.Fingerprint == status.Fingerprint {
+		return false
+	}
+	return e.Issuer != status.Issuer || e.BaseDomain != status.BaseDomain
+}
+
+// damped reports whether the latest probe results of the entry disagree with
+// its routing state.
+func (e *InventoryEntry) damped() bool {
+	return (e.Routed && e.ConsecutiveFailures > 0) || (!e.Routed && e.ConsecutiveSuccesses > 0)
+}
+
+type inventoryState struct {
+	Entries []*InventoryEntry `json:"entries"`
+	// FullScans holds the time each range was last scanned completely
+	FullScans map[string]time.Time `json:"fullScans,omitempty"`
+}
+
+// InventoryStore persists an inventory.
+type InventoryStore interface {
+	// Load returns the stored inventory, or nil if nothing is stored
+	Load() ([]byte, error)
+	Save(content []byte) error
+}
+
+// FileInventoryStore stores an inventory in a file.
+type FileInventoryStore struct {
+	Path string
+}
+
+// Load reads the inventory file.
+func (s *FileInventoryStore) Load() ([]byte, error) {
+	content, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// Save atomically replaces the inventory file.
+func (s *FileInventoryStore) Save(content []byte) error {
+	return util.WriteFileAtomic(s.Path, content, 0644)
+}
+
+// Inventory records the addresses and ports found by scans so they are
+// remembered between scans and across restarts. Known addresses are probed on
+// every scan while the rest of a range is only probed by a periodic full
+// scan.
+type Inventory struct {
+	lock      sync.Mutex
+	store     InventoryStore
+	entries   map[string]*InventoryEntry
+	fullScans map[string]time.Time
+	// expiryWarned holds the fingerprints of the certificates which have
+	// been warned about expiring
+	expiryWarned map[string]bool
+}
+
+// NewInventory returns an inventory holding the content of store. If store is
+// nil the inventory is only kept in memory.
+func NewInventory(store InventoryStore) (*Inventory, error) {
+	inventory := &Inventory{
+		store:        store,
+		entries:      map[string]*InventoryEntry{},
+		fullScans:    map[string]time.Time{},
+		expiryWarned: map[string]bool{},
+	}
+	if store == nil {
+		return inventory, nil
+	}
+
+	content, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load inventory: %v", err)
+	}
+	if len(content) == 0 {
+		return inventory, nil
+	}
+	var state inventoryState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("unable to parse inventory: %v", err)
+	}
+	for _, entry := range state.Entries {
+		inventory.entries[inventoryKey(entry.Range, entry.Address, entry.Port)] = entry
+	}
+	for key, fullScan := range state.FullScans {
+		inventory.fullScans[key] = fullScan
+	}
+	return inventory, nil
+}
+
+// Save persists the inventory to its store.
+func (i *Inventory) Save() error {
+	if i.store == nil {
+		return nil
+	}
+
+	i.lock.Lock()
+	state := inventoryState{
+		Entries:   i.sortedEntries(),
+		FullScans: i.fullScans,
+	}
+	content, err := json.MarshalIndent(&state, "", "  ")
+	i.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to marshal inventory: %v", err)
+	}
+	if err := i.store.Save(content); err != nil {
+		return fmt.Errorf("unable to save inventory: %v", err)
+	}
+	return nil
+}
+
+// Entries returns a copy of every entry of the inventory.
+func (i *Inventory) Entries() []InventoryEntry {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	entries := []InventoryEntry{}
+	for _, entry := range i.sortedEntries() {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+func (i *Inventory) sortedEntries() []*InventoryEntry {
+	entries := []*InventoryEntry{}
+	for _, entry := range i.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return inventoryEntryLess(entries[a], entries[b])
+	})
+	return entries
+}
+
+func inventoryEntryLess(a, b *InventoryEntry) bool {
+	if a.Range != b.Range {
+		return a.Range < b.Range
+	}
+	if a.Address != b.Address {
+		return util.AddressLess(a.Address, b.Address)
+	}
+	return a.Port < b.Port
+}
+
+func inventoryKey(rangeKey, address string, port int64) string {
+	return rangeKey + "/" + address + "/" + strconv.FormatInt(port, 10)
+}
+
+// RangeKey identifies a monitor range in the inventory.
+func RangeKey(monitorRange *data.MonitorRange) string {
+	if len(monitorRange.IpCidr) > 0 {
+		return monitorRange.IpCidr
+	}
+	return monitorRange.IpAddressStart + "-" + monitorRange.IpAddressEnd
+}
+
+// Record updates the inventory with the outcome of probing a port of an
+// address. Failures are only recorded for addresses which are already known.
+// An address is only added to or removed from the routed targets once the
+// rise or fall threshold of the port is reached and it has held its current
+// state for the hold time of the port. A probe whose base domain failed the
+// domain policy counts as a failure, unless it served another certificate than
+// the one recorded, in which case the address is no longer routed at all.
+func (i *Inventory) Record(rangeKey, address string, monitorPort *data.MonitorPort, status *PortStatus, probeErr error, now time.Time) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	port := monitorPort.Port
+	d := portDamping(monitorPort)
+	key := inventoryKey(rangeKey, address, port)
+	entry, exists := i.entries[key]
+
+	var rejection *DomainRejectedError
+	rejected := errors.As(probeErr, &rejection) && status != nil
+	replaced := exists && status != nil && (probeErr == nil || rejected) &&
+		(entry.replacedBy(status) || (rejected && entry.Fingerprint != status.Fingerprint))
+	if replaced {
+		// the address now belongs to another cluster. The route to the old
+		// cluster is removed, and the route to the new cluster built if its
+		// domain is accepted, without waiting for the fall and rise thresholds.
+		recordEvent(corev1.EventTypeWarning, EventReasonClusterReplaced, "cluster replaced at %s:%d: %s (issuer %q) was replaced by %s (issuer %q)",
+			address, port, entry.BaseDomain, entry.Issuer, status.BaseDomain, status.Issuer)
+		clusterReplacements.WithLabelValues(portLabel(port)).Inc()
+		if entry.Routed {
+			targetTransitions.WithLabelValues(portLabel(port), "removed").Inc()
+		}
+	}
+
+	if probeErr != nil {
+		if !exists {
+			return
+		}
+		if replaced {
+			logrus.Infof("removing %s:%d from the routed targets: %v", address, port, rejection)
+			*entry = InventoryEntry{
+				Range:               rangeKey,
+				Address:             address,
+				Port:                port,
+				FirstSeen:           now,
+				ConsecutiveFailures: 1,
+				RoutedChanged:       now,
+			}
+			entry.identify(status, now)
+			return
+		}
+		entry.ConsecutiveFailures++
+		entry.ConsecutiveSuccesses = 0
+		if entry.updateRouted(d, now) {
+			logrus.Infof("removing %s:%d from the routed targets after %d failed probes", address, port, entry.ConsecutiveFailures)
+			targetTransitions.WithLabelValues(portLabel(port), "removed").Inc()
+		} else if entry.damped() {
+			logrus.Infof("keeping %s:%d routed after %d of %d failed probes", address, port, entry.ConsecutiveFailures, d.fall)
+		}
+		if !entry.Routed && entry.ConsecutiveFailures >= inventoryForgetAfter {
+			delete(i.entries, key)
+		}
+		return
+	}
+
+	if replaced {
+		exists = false
+	} else if exists && len(entry.Fingerprint) > 0 && entry.Fingerprint != status.Fingerprint {
+		logrus.Infof("certificate of %s at %s:%d was rotated", entry.BaseDomain, address, port)
+	}
+
+	if !exists {
+		entry = &InventoryEntry{
+			Range:     rangeKey,
+			Address:   address,
+			Port:      port,
+			FirstSeen: now,
+		}
+		i.entries[key] = entry
+	}
+	entry.ConsecutiveFailures = 0
+	entry.ConsecutiveSuccesses++
+	entry.identify(status, now)
+	if replaced {
+		entry.Routed = true
+		entry.RoutedChanged = now
+		targetTransitions.WithLabelValues(portLabel(port), "added").Inc()
+	} else if entry.updateRouted(d, now) {
+		logrus.Infof("adding %s:%d to the routed targets after %d successful probes", address, port, entry.ConsecutiveSuccesses)
+		targetTransitions.WithLabelValues(portLabel(port), "added").Inc()
+	} else if entry.damped() {
+		logrus.Infof("not routing %s:%d yet after %d of %d successful probes", address, port, entry.ConsecutiveSuccesses, d.rise)
+	}
+}
+
+// identify records the certificate a probe of the entry's port found.
+func (e *InventoryEntry) identify(status *PortStatus, now time.Time) {
+	e.LastSeen = now
+	e.BaseDomain = status.BaseDomain
+	e.Fingerprint = status.Fingerprint
+	e.Issuer = status.Issuer
+	e.NotAfter = status.NotAfter
+	e.SelfSigned = status.SelfSigned
+}
+
+// updateMetrics publishes the number of addresses whose routing state is
+// being held back by damping and the state of the certificates served.
+func (i *Inventory) updateMetrics(now time.Time, expiryWarning time.Duration) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.updateCertificateMetrics(now, expiryWarning)
+
+	dampedTargets.Reset()
+	for _, entry := range i.entries {
+		if !entry.damped() {
+			continue
+		}
+		state := "rising"
+		if entry.Routed {
+			state = "falling"
+		}
+		dampedTargets.WithLabelValues(portLabel(entry.Port), state).Inc()
+	}
+}
+
+// KnownAddresses returns the addresses of a range which are in the inventory.
+func (i *Inventory) KnownAddresses(rangeKey string) map[string]bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	addresses := map[string]bool{}
+	for _, entry := range i.entries {
+		if entry.Range == rangeKey {
+			addresses[entry.Address] = true
+		}
+	}
+	return addresses
+}
+
+// FullScanDue reports whether a range should be scanned completely, rather
+// than only its known addresses.
+func (i *Inventory) FullScanDue(rangeKey string, interval time.Duration, now time.Time) bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	lastFullScan, exists := i.fullScans[rangeKey]
+	return !exists || now.Sub(lastFullScan) >= interval
+}
+
+// FullScanCompleted records that every address of a range was probed.
+func (i *Inventory) FullScanCompleted(rangeKey string, now time.Time) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	i.fullScans[rangeKey] = now
+}
+
+// Prune drops the entries of ranges which are no longer monitored.
+func (i *Inventory) Prune(monitorRanges []data.MonitorRange) {
+	rangeKeys := map[string]bool{}
+	for idx := range monitorRanges {
+		rangeKeys[RangeKey(&monitorRanges[idx])] = true
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for key, entry := range i.entries {
+		if !rangeKeys[entry.Range] {
+			delete(i.entries, key)
+		}
+	}
+	for rangeKey := range i.fullScans {
+		if !rangeKeys[rangeKey] {
+			delete(i.fullScans, rangeKey)
+		}
+	}
+}
+
+// Apply sets the targets of the ports of a range, and the base domain of each
+// target, to the routed addresses in the inventory.
+func (i *Inventory) Apply(monitorRange *data.MonitorRange) {
+	rangeKey := RangeKey(monitorRange)
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	entries := i.sortedEntries()
+
+	mu.Lock()
+	defer mu.Unlock()
+	monitorRange.TargetDomains = map[string]string{}
+	monitorRange.TargetFirstSeen = map[string]time.Time{}
+	for idx := range monitorRange.MonitorPorts {
+		monitorPort := &monitorRange.MonitorPorts[idx]
+		monitorPort.Targets = []string{}
+		monitorPort.TargetIssuers = map[string]string{}
+		for _, entry := range entries {
+			if entry.Range != rangeKey || entry.Port != monitorPort.Port || !entry.Routed {
+				continue
+			}
+			monitorPort.Targets = append(monitorPort.Targets, entry.Address)
+			if len(entry.Issuer) > 0 {
+				monitorPort.TargetIssuers[entry.Address] = entry.Issuer
+			}
+			if len(entry.BaseDomain) > 0 {
+				monitorRange.TargetDomains[entry.Address] = entry.BaseDomain
+			}
+			if firstSeen, exists := monitorRange.TargetFirstSeen[entry.Address]; !exists || entry.FirstSeen.Before(firstSeen) {
+				monitorRange.TargetFirstSeen[entry.Address] = entry.FirstSeen
+			}
+		}
+	}
+}
+
+// certificateFingerprint returns the SHA-256 fingerprint of the leaf
+// certificate.
+func certificateFingerprint(certs []*x509.Certificate) string {
+	if len(certs) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestInventory(t *testing.T) {
+	store := &FileInventoryStore{Path: filepath.Join(t.TempDir(), "inventory.json")}
+	inventory, err := NewInventory(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	firstSeen := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	lastSeen := firstSeen.Add(time.Minute)
+	apiPort := &data.MonitorPort{Port: 6443}
+	ingressPort := &data.MonitorPort{Port: 443}
+	status := &PortStatus{BaseDomain: "cluster.example.com", Fingerprint: "abc"}
+	inventory.Record("192.168.1.0/24", "192.168.1.10", apiPort, status, nil, firstSeen)
+	inventory.Record("192.168.1.0/24", "192.168.1.10", apiPort, status, nil, lastSeen)
+	inventory.Record("192.168.1.0/24", "192.168.1.9", ingressPort, status, nil, firstSeen)
+	inventory.Record("192.168.1.0/24", "192.168.1.9", ingressPort, nil, errors.New("timeout"), lastSeen)
+	// failures of unknown addresses are not recorded
+	inventory.Record("192.168.1.0/24", "192.168.1.11", ingressPort, nil, errors.New("timeout"), lastSeen)
+	inventory.FullScanCompleted("192.168.1.0/24", firstSeen)
+
+	if err := inventory.Save(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewInventory(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []InventoryEntry{
+		{
+			Range:               "192.168.1.0/24",
+			Address:             "192.168.1.9",
+			Port:                443,
+			BaseDomain:          "cluster.example.com",
+			Fingerprint:         "abc",
+			FirstSeen:           firstSeen,
+			LastSeen:            firstSeen,
+			ConsecutiveFailures: 1,
+			RoutedChanged:       lastSeen,
+		},
+		{
+			Range:                "192.168.1.0/24",
+			Address:              "192.168.1.10",
+			Port:                 6443,
+			BaseDomain:           "cluster.example.com",
+			Fingerprint:          "abc",
+			FirstSeen:            firstSeen,
+			LastSeen:             lastSeen,
+			ConsecutiveSuccesses: 2,
+			Routed:               true,
+			RoutedChanged:        firstSeen,
+		},
+	}
+	if entries := restored.Entries(); !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entries)
+	}
+	if restored.FullScanDue("192.168.1.0/24", time.Hour, firstSeen.Add(time.Minute)) {
+		t.Fatal("expected the full scan not to be due")
+	}
+	if !restored.FullScanDue("192.168.1.0/24", time.Hour, firstSeen.Add(2*time.Hour)) {
+		t.Fatal("expected the full scan to be due")
+	}
+
+	monitorRange := data.MonitorRange{
+		IpCidr:       "192.168.1.0/24",
+		MonitorPorts: []data.MonitorPort{{Port: 6443}, {Port: 443}},
+	}
+	restored.Apply(&monitorRange)
+	if !reflect.DeepEqual(monitorRange.MonitorPorts[0].Targets, []string{"192.168.1.10"}) ||
+		len(monitorRange.MonitorPorts[1].Targets) != 0 {
+		t.Fatalf("expected only live addresses as targets, got %+v", monitorRange.MonitorPorts)
+	}
+
+	for i := 1; i < inventoryForgetAfter; i++ {
+		restored.Record("192.168.1.0/24", "192.168.1.9", ingressPort, nil, errors.New("timeout"), lastSeen)
+	}
+	if known := restored.KnownAddresses("192.168.1.0/24"); !reflect.DeepEqual(known, map[string]bool{"192.168.1.10": true}) {
+		t.Fatalf("expected the failing address to be forgotten, got %v", known)
+	}
+
+	restored.Prune([]data.MonitorRange{{IpCidr: "192.168.2.0/24"}})
+	if entries := restored.Entries(); len(entries) != 0 {
+		t.Fatalf("expected the entries of removed ranges to be pruned, got %+v", entries)
+	}
+}
+
+func TestInventoryDamping(t *testing.T) {
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitorPort := &data.MonitorPort{Port: 6443, Rise: 2, Fall: 3, HoldTime: 60000}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	status := &PortStatus{BaseDomain: "cluster.example.com"}
+	failure := errors.New("timeout")
+
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		probeErr error
+		routed   bool
+	}{
+		{name: "first success is below the rise threshold", offset: 0, routed: false},
+		{name: "second success reaches the rise threshold", offset: 10 * time.Second, routed: true},
+		{name: "a single failure is below the fall threshold", offset: 20 * time.Second, probeErr: failure, routed: true},
+		{name: "a success resets the failures", offset: 30 * time.Second, routed: true},
+		{name: "first failure", offset: 40 * time.Second, probeErr: failure, routed: true},
+		{name: "second failure", offset: 50 * time.Second, probeErr: failure, routed: true},
+		{name: "fall threshold within the hold time", offset: 60 * time.Second, probeErr: failure, routed: true},
+		{name: "fall threshold after the hold time", offset: 70 * time.Second, probeErr: failure, routed: false},
+		{name: "rise threshold within the hold time", offset: 80 * time.Second, routed: false},
+		{name: "rise threshold reached again", offset: 90 * time.Second, routed: false},
+		{name: "rise threshold after the hold time", offset: 130 * time.Second, routed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probeStatus := status
+			if tt.probeErr != nil {
+				probeStatus = nil
+			}
+			inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, probeStatus, tt.probeErr, start.Add(tt.offset))
+
+			monitorRange := data.MonitorRange{IpCidr: "192.168.1.0/24", MonitorPorts: []data.MonitorPort{*monitorPort}}
+			inventory.Apply(&monitorRange)
+			if routed := len(monitorRange.MonitorPorts[0].Targets) > 0; routed != tt.routed {
+				t.Fatalf("expected routed: %v, got %v", tt.routed, routed)
+			}
+		})
+	}
+}
+
+func TestInventoryClusterReplaced(t *testing.T) {
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitorPort := &data.MonitorPort{Port: 6443, Rise: 3, Fall: 3}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	old := &PortStatus{BaseDomain: "old.example.com", Fingerprint: "aaa", Issuer: "CN=kube-apiserver-lb-signer-old"}
+	rotated := &PortStatus{BaseDomain: "old.example.com", Fingerprint: "bbb", Issuer: "CN=kube-apiserver-lb-signer-old"}
+	replacement := &PortStatus{BaseDomain: "new.example.com", Fingerprint: "ccc", Issuer: "CN=kube-apiserver-lb-signer-new"}
+
+	targetDomain := func() string {
+		monitorRange := data.MonitorRange{IpCidr: "192.168.1.0/24", MonitorPorts: []data.MonitorPort{*monitorPort}}
+		inventory.Apply(&monitorRange)
+		if len(monitorRange.MonitorPorts[0].Targets) == 0 {
+			return ""
+		}
+		return monitorRange.TargetDomains["192.168.1.10"]
+	}
+
+	for i := 0; i < 3; i++ {
+		inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, old, nil, start.Add(time.Duration(i)*time.Minute))
+	}
+	if domain := targetDomain(); domain != "old.example.com" {
+		t.Fatalf("expected old.example.com to be routed, got %q", domain)
+	}
+
+	// a rotated certificate of the same cluster doesn't change the route
+	inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, rotated, nil, start.Add(3*time.Minute))
+	entries := inventory.Entries()
+	if domain := targetDomain(); domain != "old.example.com" || !entries[0].FirstSeen.Equal(start) {
+		t.Fatalf("expected the rotated certificate to keep the route, got %q %+v", domain, entries)
+	}
+
+	// a certificate of another cluster replaces the route at once
+	inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, replacement, nil, start.Add(4*time.Minute))
+	entries = inventory.Entries()
+	if domain := targetDomain(); domain != "new.example.com" {
+		t.Fatalf("expected new.example.com to be routed, got %q", domain)
+	}
+	if !entries[0].FirstSeen.Equal(start.Add(4*time.Minute)) || entries[0].Issuer != replacement.Issuer {
+		t.Fatalf("expected the entry to be tracked from the replacement, got %+v", entries[0])
+	}
+}
+
+func TestInventoryClusterReplacedRejected(t *testing.T) {
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitorPort := &data.MonitorPort{Port: 6443, Rise: 3, Fall: 3, HoldTime: 600000}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	old := &PortStatus{BaseDomain: "old.example.com", Fingerprint: "aaa", Issuer: "CN=kube-apiserver-lb-signer-old"}
+	rogue := &PortStatus{BaseDomain: "prod.example.com", Fingerprint: "ccc", Issuer: "CN=kube-apiserver-lb-signer-rogue"}
+	rejection := &DomainRejectedError{Domain: rogue.BaseDomain, Reason: DomainRejectedDenied, Detail: "within denied domain prod.example.com"}
+
+	targets := func() []string {
+		monitorRange := data.MonitorRange{IpCidr: "192.168.1.0/24", MonitorPorts: []data.MonitorPort{*monitorPort}}
+		inventory.Apply(&monitorRange)
+		return monitorRange.MonitorPorts[0].Targets
+	}
+
+	for i := 0; i < 3; i++ {
+		inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, old, nil, start.Add(time.Duration(i)*time.Minute))
+	}
+	if routed := targets(); len(routed) != 1 {
+		t.Fatalf("expected old.example.com to be routed, got %v", routed)
+	}
+
+	// the rejected cluster isn't routed to, and the route to the old cluster
+	// is removed without waiting for the fall threshold or hold time
+	inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, rogue, rejection, start.Add(3*time.Minute))
+	if routed := targets(); len(routed) != 0 {
+		t.Fatalf("expected the address to be removed from the targets, got %v", routed)
+	}
+	entries := inventory.Entries()
+	if len(entries) != 1 || entries[0].Routed || entries[0].Fingerprint != rogue.Fingerprint || entries[0].ConsecutiveSuccesses != 0 {
+		t.Fatalf("expected the entry to track the rejected cluster, got %+v", entries)
+	}
+
+	// further rejections count as failures until the address is forgotten
+	for i := 1; i < inventoryForgetAfter; i++ {
+		inventory.Record("192.168.1.0/24", "192.168.1.10", monitorPort, rogue, rejection, start.Add(time.Duration(3+i)*time.Minute))
+		if routed := targets(); len(routed) != 0 {
+			t.Fatalf("expected the rejected cluster not to be routed, got %v", routed)
+		}
+	}
+	if entries := inventory.Entries(); len(entries) != 0 {
+		t.Fatalf("expected the rejected address to be forgotten, got %+v", entries)
+	}
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	targetTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "haproxy_dyna_configure_target_transitions_total",
+		Help: "Number of times an address was added to or removed from the routed targets of a port.",
+	}, []string{"port", "transition"})
+
+	dampedTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_dyna_configure_damped_targets",
+		Help: "Number of addresses whose probe results disagree with their routing state, waiting for the rise or fall threshold or the hold time.",
+	}, []string{"port", "state"})
+
+	clusterReplacements = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "haproxy_dyna_configure_cluster_replacements_total",
+		Help: "Number of times a port of an address started serving the certificate of another cluster.",
+	}, []string{"port"})
+
+	certificateExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_dyna_configure_certificate_expiry_days",
+		Help: "Days until the first certificate served on a port of a cluster expires.",
+	}, []string{"base_domain", "port"})
+
+	certificateInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_dyna_configure_certificate_info",
+		Help: "Issuer of the certificates served on a port of a cluster.",
+	}, []string{"base_domain", "port", "issuer"})
+
+	certificateSelfSigned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_dyna_configure_certificate_self_signed",
+		Help: "Whether a certificate chain served on a port of a cluster ends in a self-signed certificate.",
+	}, []string{"base_domain", "port"})
+
+	rejectedDomains = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "haproxy_dyna_configure_rejected_domains_total",
+		Help: "Number of probes which found a base domain rejected by the domain policy.",
+	}, []string{"port", "reason"})
+
+	domainConflicts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_dyna_configure_domain_conflicts",
+		Help: "Number of sources claiming a port of a base domain, for ports claimed by more than one source.",
+	}, []string{"base_domain", "port"})
+
+	runtimeServerUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "haproxy_dyna_configure_runtime_server_updates_total",
+		Help: "Number of servers added, removed or enabled through the HAProxy Runtime API.",
+	}, []string{"operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(targetTransitions, dampedTargets, clusterReplacements,
+		certificateExpiryDays, certificateInfo, certificateSelfSigned, rejectedDomains, domainConflicts, runtimeServerUpdates)
+}
+
+func portLabel(port int64) string {
+	return strconv.FormatInt(port, 10)
+}
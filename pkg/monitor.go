@@ -2,18 +2,14 @@ package pkg
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net"
-	"net/http"
-	"net/netip"
 	"os"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/go-yaml/yaml"
-	"github.com/netdata/go.d.plugin/pkg/iprange"
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -27,7 +23,8 @@ const (
 	monitorConfigEnvVar = "MONITOR_CONFIG"
 )
 
-func getConfigPath() string {
+// ConfigPath returns the path of the monitor config file.
+func ConfigPath() string {
 	if val, exists := os.LookupEnv(monitorConfigEnvVar); exists {
 		return val
 	}
@@ -35,145 +32,189 @@ func getConfigPath() string {
 }
 
 func GetConfig() (*data.MonitorConfig, error) {
-	var monitorConfig data.MonitorConfig
-	configRaw, err := os.ReadFile(getConfigPath())
+	return LoadConfig(ConfigPath())
+}
+
+// LoadConfig reads and parses the monitor config at path. Ranges found in the
+// subnets json referenced by the config are appended to the monitor ranges.
+func LoadConfig(path string) (*data.MonitorConfig, error) {
+	configRaw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read monitor config: %v", err)
 	}
-	err = yaml.Unmarshal(configRaw, &monitorConfig)
-	if len(monitorConfig.BaseDomain) == 0 {
-		monitorConfig.BaseDomain = "vmc-ci.devcluster.openshift.com"
+	return parseConfig(configRaw)
+}
+
+func parseConfig(configRaw []byte) (*data.MonitorConfig, error) {
+	if errs := ValidateConfig(configRaw); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid monitor config:\n%w", errs)
 	}
+
+	var monitorConfigSpec data.MonitorConfigSpec
+	err := yaml.Unmarshal(configRaw, &monitorConfigSpec)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshall monitor config: %v", err)
 	}
-	return &monitorConfig, nil
+	return PrepareConfig(&monitorConfigSpec)
 }
 
-func Initialize(ctx context.Context) error {
-
-	monitorConfig, err := GetConfig()
-	if err != nil {
-		return fmt.Errorf("unable to get config: %v", err)
+// PrepareConfig returns the effective config for a validated monitor config
+// spec, with defaults applied, the subnets json imported and port profiles
+// expanded. The spec is not modified.
+func PrepareConfig(monitorConfigSpec *data.MonitorConfigSpec) (*data.MonitorConfig, error) {
+	monitorConfig := monitorConfigSpec.MonitorConfig
+	monitorConfig.MonitorRanges = append([]data.MonitorRange{}, monitorConfig.MonitorRanges...)
+	if len(monitorConfig.BaseDomain) == 0 {
+		monitorConfig.BaseDomain = "vmc-ci.devcluster.openshift.com"
 	}
+	defaultNamespaceRules(&monitorConfig.NamespaceRules)
 
 	if len(monitorConfig.SubnetsJson) > 0 {
-		nativeSubnetRanges, err := parseSubnetsJson(monitorConfig.SubnetsJson)
+		nativeSubnetRanges, err := parseSubnetsJson(monitorConfig.SubnetsJson, subnetsPortProfile(&monitorConfig), monitorConfig.SubnetsPortOverrides)
 		if err != nil {
-			return errors.Wrap(err, "unable to parse native subnet json")
+			return nil, errors.Wrap(err, "unable to parse native subnet json")
 		}
 		monitorConfig.MonitorRanges = append(monitorConfig.MonitorRanges, nativeSubnetRanges...)
 	}
-	return nil
-}
-
-func CheckRanges(ctx context.Context) (*data.MonitorConfigSpec, error) {
-	var wg sync.WaitGroup
-	const maxThreads = 10
-	var activeThreads = 0
 
-	for idx := range monitorConfig.MonitorConfig.MonitorRanges {
-		if activeThreads >= maxThreads {
-			wg.Wait()
-			activeThreads = 0
-		}
-		wg.Add(1)
-		activeThreads++
-		go CheckRange(ctx, &wg, &monitorConfig.MonitorConfig.MonitorRanges[idx])
+	if err := expandPortProfiles(&monitorConfig); err != nil {
+		return nil, fmt.Errorf("unable to expand port profiles: %w", err)
 	}
-	wg.Wait()
 	return &monitorConfig, nil
 }
 
-func CheckPort(ctx context.Context, wg *sync.WaitGroup, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) {
-	defer wg.Done()
-	client := http.Client{
-		Timeout: time.Duration(monitorConfig.MonitorConfig.CheckTimeout) * time.Millisecond,
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
-
-	protocol := monitorPort.Protocol
-	if len(protocol) == 0 {
-		protocol = "https"
-		mu.Lock()
-		monitorPort.Protocol = protocol
-		mu.Unlock()
-	}
-	url := fmt.Sprintf("%s://%s:%d", protocol, ip, monitorPort.Port)
-	logrus.Debugf("checking URL %s", url)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		logrus.Error(err)
-		return
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return
-	}
+// SetConfig replaces the monitor config used when checking ranges. Scans
+// update the targets of the ranges in place, so the ranges and their ports are
+// copied rather than shared with the caller.
+func SetConfig(config *data.MonitorConfig) {
 	mu.Lock()
-	monitorPort.Targets = append(monitorPort.Targets, ip)
-	mu.Unlock()
-	if resp.TLS != nil {
-		for _, cert := range resp.TLS.PeerCertificates {
-			for _, dnsname := range cert.DNSNames {
-				var prefix string
-				mu.Lock()
-				if len(monitorPort.PathPrefix) > 0 {
-					prefix = monitorPort.PathPrefix
-				} else if len(monitorPort.PathMatch) > 0 {
-					prefix = monitorPort.PathMatch
+	defer mu.Unlock()
+	monitorConfig.MonitorConfig = *config
+	monitorConfig.MonitorConfig.MonitorRanges = copyMonitorRanges(config.MonitorRanges)
+}
+
+// copyMonitorRanges returns a copy of the ranges which shares none of the
+// targets found by scans, or the slices holding them, with the original.
+func copyMonitorRanges(monitorRanges []data.MonitorRange) []data.MonitorRange {
+	if monitorRanges == nil {
+		return nil
+	}
+	copied := make([]data.MonitorRange, len(monitorRanges))
+	for idx, monitorRange := range monitorRanges {
+		if monitorRange.MonitorPorts != nil {
+			monitorPorts := make([]data.MonitorPort, len(monitorRange.MonitorPorts))
+			for portIdx, monitorPort := range monitorRange.MonitorPorts {
+				if monitorPort.Targets != nil {
+					monitorPort.Targets = append([]string{}, monitorPort.Targets...)
 				}
-				mu.Unlock()
-				if strings.HasPrefix(dnsname, prefix) {
-					splits := strings.SplitAfter(dnsname, prefix)
-					if len(splits) < 2 {
-						continue
+				if monitorPort.TargetIssuers != nil {
+					targetIssuers := map[string]string{}
+					for target, issuer := range monitorPort.TargetIssuers {
+						targetIssuers[target] = issuer
 					}
-
-					mu.Lock()
-					monitorRange.BaseDomain = splits[1]
-					logrus.Infof("found base domain %s", monitorRange.BaseDomain)
-					mu.Unlock()
+					monitorPort.TargetIssuers = targetIssuers
 				}
+				monitorPorts[portIdx] = monitorPort
+			}
+			monitorRange.MonitorPorts = monitorPorts
+		}
+		if monitorRange.TargetDomains != nil {
+			targetDomains := map[string]string{}
+			for target, baseDomain := range monitorRange.TargetDomains {
+				targetDomains[target] = baseDomain
+			}
+			monitorRange.TargetDomains = targetDomains
+		}
+		if monitorRange.TargetFirstSeen != nil {
+			targetFirstSeen := map[string]time.Time{}
+			for target, firstSeen := range monitorRange.TargetFirstSeen {
+				targetFirstSeen[target] = firstSeen
 			}
+			monitorRange.TargetFirstSeen = targetFirstSeen
 		}
+		copied[idx] = monitorRange
 	}
-
+	return copied
 }
 
-func CheckRange(ctx context.Context, cWaitGroup *sync.WaitGroup, monitorRange *data.MonitorRange) {
-	defer cWaitGroup.Done()
-	parseRange, err := iprange.ParseRange(fmt.Sprintf("%s-%s", monitorRange.IpAddressStart, monitorRange.IpAddressEnd))
+func Initialize(ctx context.Context) error {
+
+	config, err := GetConfig()
 	if err != nil {
-		logrus.Error(err)
-		return
+		return fmt.Errorf("unable to get config: %v", err)
 	}
 
-	ip, err := netip.ParseAddr(monitorRange.IpAddressStart)
+	SetConfig(config)
+	return nil
+}
+
+// PortStatus is what was learnt from a port which responded to a probe.
+type PortStatus struct {
+	// BaseDomain is the base domain found in the certificate served
+	BaseDomain string
+	// Fingerprint is the SHA-256 fingerprint of the certificate served
+	Fingerprint string
+	// Issuer is the issuer of the certificate served
+	Issuer string
+	// NotAfter is when the certificate served expires
+	NotAfter time.Time
+	// SelfSigned is set if the certificate chain served ends in a self-signed
+	// certificate
+	SelfSigned bool
+}
+
+// CheckPort probes a port of an address in a range. If the port responds the
+// address is added to the port's targets and the base domain found in the
+// certificate it served is recorded for the address. If the base domain fails
+// the domain policy the address isn't added, and the status of the port is
+// returned with a *DomainRejectedError.
+func CheckPort(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error) {
+	mu.Lock()
+	protocol := monitorPort.Protocol
+	if len(protocol) == 0 {
+		protocol = ProtocolHTTPS
+		monitorPort.Protocol = protocol
+	}
+	port, sni := monitorPort.Port, monitorPort.SNI
+	mu.Unlock()
+
+	logrus.Debugf("checking %s %s", protocol, net.JoinHostPort(ip, strconv.FormatInt(port, 10)))
+	result, err := probe(ctx, protocol, ip, port, sni, time.Duration(monitorConfig.MonitorConfig.CheckTimeout)*time.Millisecond)
 	if err != nil {
-		logrus.Error(err)
-		return
+		return nil, err
 	}
 
-	for idx := range monitorRange.MonitorPorts {
-		monitorRange.MonitorPorts[idx].Targets = []string{}
+	mu.Lock()
+	defer mu.Unlock()
+	status := &PortStatus{
+		BaseDomain:  certificateBaseDomain(result.Certificates, monitorPort),
+		Fingerprint: certificateFingerprint(result.Certificates),
+	}
+	if len(result.Certificates) > 0 {
+		status.Issuer = result.Certificates[0].Issuer.String()
+		status.NotAfter = result.Certificates[0].NotAfter
+		status.SelfSigned = chainSelfSigned(result.Certificates)
+	}
+	if len(status.BaseDomain) > 0 {
+		if rejection := checkDomainPolicy(&monitorConfig.MonitorConfig.DomainPolicy, monitorRange, status.BaseDomain); rejection != nil {
+			logrus.Warnf("not routing %s:%d: %v", ip, port, rejection)
+			rejectedDomains.WithLabelValues(portLabel(port), rejection.Reason).Inc()
+			return status, rejection
+		}
 	}
-	var wg sync.WaitGroup
-	const maxThreads = 25
-	var activeThreads = 0
-	for parseRange.Contains(net.ParseIP(ip.String())) {
-		for idx := range monitorRange.MonitorPorts {
-			if activeThreads >= maxThreads {
-				wg.Wait()
-				activeThreads = 0
-			}
-			wg.Add(1)
-			activeThreads++
-			go CheckPort(ctx, &wg, &monitorRange.MonitorPorts[idx], monitorRange, ip.String())
+	monitorPort.Targets = append(monitorPort.Targets, ip)
+	if len(status.Issuer) > 0 {
+		if monitorPort.TargetIssuers == nil {
+			monitorPort.TargetIssuers = map[string]string{}
+		}
+		monitorPort.TargetIssuers[ip] = status.Issuer
+	}
+	if len(status.BaseDomain) > 0 {
+		if monitorRange.TargetDomains == nil {
+			monitorRange.TargetDomains = map[string]string{}
 		}
-		ip = ip.Next()
+		monitorRange.TargetDomains[ip] = status.BaseDomain
+		logrus.Infof("found base domain %s at %s", status.BaseDomain, ip)
 	}
-	wg.Wait()
+	return status, nil
 }
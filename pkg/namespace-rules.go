@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	JobIdentitySourceEnv        = "env"
+	JobIdentitySourceLabel      = "label"
+	JobIdentitySourceAnnotation = "annotation"
+
+	defaultNamespacePrefix       = "ci-ln-"
+	defaultJobIdentityName       = "JOB_NAME_HASH"
+	defaultClusterDomainTemplate = "{{.Namespace}}-{{.JobHash}}.{{.BaseDomain}}"
+	defaultLookupHostTemplate    = "api-int.{{.ClusterDomain}}"
+)
+
+// ClusterNameData is passed to the cluster domain and lookup host templates.
+// ClusterDomain is only set for the lookup host template.
+type ClusterNameData struct {
+	Namespace     string
+	JobHash       string
+	BaseDomain    string
+	ClusterDomain string
+}
+
+// NamespaceRules are the compiled namespace rules of a monitor config.
+type NamespaceRules struct {
+	data.NamespaceRules
+	regex         *regexp.Regexp
+	selector      labels.Selector
+	clusterDomain *template.Template
+	lookupHost    *template.Template
+}
+
+// defaultNamespaceRules fills in the namespace rules which aren't set. The
+// default prefix only applies if no other namespace matching is configured.
+func defaultNamespaceRules(rules *data.NamespaceRules) {
+	if len(rules.Prefix) == 0 && len(rules.Regex) == 0 && len(rules.LabelSelector) == 0 {
+		rules.Prefix = defaultNamespacePrefix
+	}
+	if len(rules.JobIdentity.Source) == 0 {
+		rules.JobIdentity.Source = JobIdentitySourceEnv
+	}
+	if len(rules.JobIdentity.Name) == 0 {
+		rules.JobIdentity.Name = defaultJobIdentityName
+	}
+	if len(rules.ClusterDomainTemplate) == 0 {
+		rules.ClusterDomainTemplate = defaultClusterDomainTemplate
+	}
+	if len(rules.LookupHostTemplate) == 0 {
+		rules.LookupHostTemplate = defaultLookupHostTemplate
+	}
+}
+
+// CompileNamespaceRules compiles the regex, label selector and templates of the
+// namespace rules. Every problem found is returned.
+func CompileNamespaceRules(rules data.NamespaceRules) (*NamespaceRules, error) {
+	var errs ValidationErrors
+	path := "monitor-config.namespace-rules"
+
+	defaultNamespaceRules(&rules)
+	compiled := &NamespaceRules{
+		NamespaceRules: rules,
+		selector:       labels.Everything(),
+	}
+
+	var err error
+	if len(rules.Regex) > 0 {
+		if compiled.regex, err = regexp.Compile(rules.Regex); err != nil {
+			errs.add(path+".regex", "%v", err)
+		}
+	}
+	if len(rules.LabelSelector) > 0 {
+		if compiled.selector, err = labels.Parse(rules.LabelSelector); err != nil {
+			errs.add(path+".label-selector", "%v", err)
+		}
+	}
+
+	switch rules.JobIdentity.Source {
+	case JobIdentitySourceEnv, JobIdentitySourceLabel, JobIdentitySourceAnnotation:
+	default:
+		errs.add(path+".job-identity.source", "must be one of %s, %s or %s",
+			JobIdentitySourceEnv, JobIdentitySourceLabel, JobIdentitySourceAnnotation)
+	}
+
+	if compiled.clusterDomain, err = template.New("cluster-domain").Option("missingkey=error").Parse(rules.ClusterDomainTemplate); err != nil {
+		errs.add(path+".cluster-domain-template", "%v", err)
+	}
+	if compiled.lookupHost, err = template.New("lookup-host").Option("missingkey=error").Parse(rules.LookupHostTemplate); err != nil {
+		errs.add(path+".lookup-host-template", "%v", err)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// templates which parse may still refer to fields which don't exist
+	if _, err = compiled.ClusterDomain("namespace", "hash", "example.com"); err != nil {
+		errs.add(path+".cluster-domain-template", "%v", err)
+	} else if _, err = compiled.LookupHost("namespace", "hash", "example.com"); err != nil {
+		errs.add(path+".lookup-host-template", "%v", err)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return compiled, nil
+}
+
+// MatchNamespace reports whether pods in the namespace are tracked.
+func (r *NamespaceRules) MatchNamespace(name string, namespaceLabels map[string]string) bool {
+	if !strings.HasPrefix(name, r.Prefix) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(name) {
+		return false
+	}
+	return r.selector.Matches(labels.Set(namespaceLabels))
+}
+
+func executeTemplate(tmpl *template.Template, nameData ClusterNameData) (string, error) {
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, nameData); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// ClusterDomain returns the domain of the cluster run by a job.
+func (r *NamespaceRules) ClusterDomain(namespace, jobHash, baseDomain string) (string, error) {
+	return executeTemplate(r.clusterDomain, ClusterNameData{
+		Namespace:  namespace,
+		JobHash:    jobHash,
+		BaseDomain: baseDomain,
+	})
+}
+
+// LookupHost returns the host which is resolved to find the VIPs of the
+// cluster run by a job.
+func (r *NamespaceRules) LookupHost(namespace, jobHash, baseDomain string) (string, error) {
+	clusterDomain, err := r.ClusterDomain(namespace, jobHash, baseDomain)
+	if err != nil {
+		return "", err
+	}
+	return executeTemplate(r.lookupHost, ClusterNameData{
+		Namespace:     namespace,
+		JobHash:       jobHash,
+		BaseDomain:    baseDomain,
+		ClusterDomain: clusterDomain,
+	})
+}
@@ -2,6 +2,7 @@ package pkg
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
@@ -9,7 +10,13 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-func parseSubnetsJson(subnetsFile string) ([]data.MonitorRange, error) {
+// subnetRangeEnd is the index of the last address in a subnet's ipAddresses
+// that is monitored.
+const subnetRangeEnd = 10
+
+// parseSubnetsJson returns a monitor range for each vlan in the subnets json.
+// The ports of each range are resolved from portProfile and portOverrides.
+func parseSubnetsJson(subnetsFile string, portProfile string, portOverrides map[string]data.MonitorPort) ([]data.MonitorRange, error) {
 	logrus.Infof("reading subnets from %s", subnetsFile)
 	subnetsBytes, err := os.ReadFile(subnetsFile)
 	if err != nil {
@@ -24,28 +31,33 @@ func parseSubnetsJson(subnetsFile string) ([]data.MonitorRange, error) {
 	}
 
 	monitorRanges := []data.MonitorRange{}
-	for datacenter, vlans := range subnetsUntyped {
+	for datacenter, vlansUntyped := range subnetsUntyped {
 		logrus.Infof("traversing datacenter %s", datacenter)
-		for vlan, subnetUntyped := range vlans.(map[string]interface{}) {
+		vlans, ok := vlansUntyped.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("datacenter %s is not an object", datacenter)
+		}
+		for vlan, subnetUntyped := range vlans {
 			logrus.Infof("traversing vlan %s", vlan)
-			subnet := subnetUntyped.(map[string]interface{})
-			ipAddresses := subnet["ipAddresses"].([]interface{})
+			subnet, ok := subnetUntyped.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("vlan %s in datacenter %s is not an object", vlan, datacenter)
+			}
+			ipAddresses, ok := subnet["ipAddresses"].([]interface{})
+			if !ok || len(ipAddresses) <= subnetRangeEnd {
+				return nil, fmt.Errorf("vlan %s in datacenter %s must have at least %d ipAddresses", vlan, datacenter, subnetRangeEnd+1)
+			}
+			ipAddressStart, startOk := ipAddresses[0].(string)
+			ipAddressEnd, endOk := ipAddresses[subnetRangeEnd].(string)
+			if !startOk || !endOk {
+				return nil, fmt.Errorf("vlan %s in datacenter %s has a non-string ipAddress", vlan, datacenter)
+			}
 
 			monitorRange := data.MonitorRange{
-				IpAddressStart: ipAddresses[0].(string),
-				IpAddressEnd:   ipAddresses[10].(string),
-				MonitorPorts: []data.MonitorPort{
-					{
-						Port:      6443,
-						Name:      "api",
-						PathMatch: "api",
-					},
-					{
-						Port:       443,
-						Name:       "ingress-https",
-						PathPrefix: "*.apps",
-					},
-				},
+				IpAddressStart: ipAddressStart,
+				IpAddressEnd:   ipAddressEnd,
+				PortProfile:    portProfile,
+				PortOverrides:  portOverrides,
 			}
 			monitorRanges = append(monitorRanges, monitorRange)
 		}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// DefaultPortProfile is the built-in port profile used by ranges imported from
+// the subnets json when no other profile is configured.
+const DefaultPortProfile = "openshift-default"
+
+func builtinPortProfiles() map[string][]data.MonitorPort {
+	return map[string][]data.MonitorPort{
+		DefaultPortProfile: {
+			{
+				Port:      6443,
+				Name:      "api",
+				PathMatch: "api",
+			},
+			{
+				Port:       443,
+				Name:       "ingress-https",
+				PathPrefix: "*.apps",
+			},
+		},
+	}
+}
+
+// portProfiles returns the port profiles defined by the config along with the
+// built-in profiles it doesn't redefine.
+func portProfiles(monitorConfig *data.MonitorConfig) map[string][]data.MonitorPort {
+	profiles := builtinPortProfiles()
+	for name, ports := range monitorConfig.PortProfiles {
+		profiles[name] = ports
+	}
+	return profiles
+}
+
+// subnetsPortProfile returns the port profile used by ranges imported from the
+// subnets json.
+func subnetsPortProfile(monitorConfig *data.MonitorConfig) string {
+	if len(monitorConfig.SubnetsPortProfile) > 0 {
+		return monitorConfig.SubnetsPortProfile
+	}
+	return DefaultPortProfile
+}
+
+func portProfilePath(name string) string {
+	return fmt.Sprintf("monitor-config.port-profiles.%s", name)
+}
+
+// overridePort returns port with every field set in override applied to it.
+func overridePort(port, override data.MonitorPort) data.MonitorPort {
+	if override.Port != 0 {
+		port.Port = override.Port
+	}
+	if len(override.PathPrefix) > 0 {
+		port.PathPrefix = override.PathPrefix
+	}
+	if len(override.PathMatch) > 0 {
+		port.PathMatch = override.PathMatch
+	}
+	if len(override.Protocol) > 0 {
+		port.Protocol = override.Protocol
+	}
+	if len(override.SNI) > 0 {
+		port.SNI = override.SNI
+	}
+	if override.Rise != 0 {
+		port.Rise = override.Rise
+	}
+	if override.Fall != 0 {
+		port.Fall = override.Fall
+	}
+	if override.HoldTime != 0 {
+		port.HoldTime = override.HoldTime
+	}
+	if len(override.DomainRules) > 0 {
+		port.DomainRules = override.DomainRules
+	}
+	return port
+}
+
+// resolvePorts returns the effective ports for a profile, its overrides and any
+// additional ports, along with the YAML path which defined each port. Overrides
+// are keyed by the name of the profile port they apply to.
+func resolvePorts(profiles map[string][]data.MonitorPort, profile string, overrides map[string]data.MonitorPort, monitorPorts []data.MonitorPort, profilePath, overridesPath, portsPath string) ([]data.MonitorPort, []string, error) {
+	var errs ValidationErrors
+	ports := []data.MonitorPort{}
+	paths := []string{}
+
+	if len(profile) > 0 {
+		profilePorts, exists := profiles[profile]
+		if !exists {
+			errs.add(profilePath, "unknown port profile %q", profile)
+			return nil, nil, errs
+		}
+
+		applied := map[string]bool{}
+		for idx, port := range profilePorts {
+			if override, exists := overrides[port.Name]; exists && len(port.Name) > 0 {
+				ports = append(ports, overridePort(port, override))
+				paths = append(paths, fmt.Sprintf("%s.%s", overridesPath, port.Name))
+				applied[port.Name] = true
+				continue
+			}
+			ports = append(ports, port)
+			paths = append(paths, fmt.Sprintf("%s[%d]", portProfilePath(profile), idx))
+		}
+
+		names := []string{}
+		for name := range overrides {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !applied[name] {
+				errs.add(fmt.Sprintf("%s.%s", overridesPath, name), "port profile %q has no port named %q", profile, name)
+			}
+		}
+	} else if len(overrides) > 0 {
+		errs.add(overridesPath, "port overrides require a port profile")
+	}
+
+	for idx, port := range monitorPorts {
+		ports = append(ports, port)
+		paths = append(paths, fmt.Sprintf("%s[%d]", portsPath, idx))
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return ports, paths, nil
+}
+
+// expandPortProfiles replaces the port profile and overrides of each range with
+// the ports they resolve to.
+func expandPortProfiles(monitorConfig *data.MonitorConfig) error {
+	profiles := portProfiles(monitorConfig)
+	for idx := range monitorConfig.MonitorRanges {
+		monitorRange := &monitorConfig.MonitorRanges[idx]
+		path := fmt.Sprintf("monitor-config.monitor-ranges[%d]", idx)
+		ports, _, err := resolvePorts(profiles, monitorRange.PortProfile, monitorRange.PortOverrides, monitorRange.MonitorPorts,
+			path+".port-profile", path+".port-overrides", path+".monitor-ports")
+		if err != nil {
+			return err
+		}
+		monitorRange.MonitorPorts = ports
+		monitorRange.PortProfile = ""
+		monitorRange.PortOverrides = nil
+	}
+	monitorConfig.PortProfiles = nil
+	monitorConfig.SubnetsPortProfile = ""
+	monitorConfig.SubnetsPortOverrides = nil
+	return nil
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestExpandPortProfiles(t *testing.T) {
+	monitorConfig := data.MonitorConfig{
+		PortProfiles: map[string][]data.MonitorPort{
+			"lab": {
+				{
+					Port:      6443,
+					Name:      "api",
+					PathMatch: "api",
+				},
+			},
+		},
+		MonitorRanges: []data.MonitorRange{
+			{
+				IpCidr:      "192.168.1.0/28",
+				PortProfile: DefaultPortProfile,
+				PortOverrides: map[string]data.MonitorPort{
+					"ingress-https": {Port: 8443},
+				},
+			},
+			{
+				IpCidr:      "192.168.2.0/28",
+				PortProfile: "lab",
+				MonitorPorts: []data.MonitorPort{
+					{
+						Port:      22623,
+						Name:      "mcs",
+						PathMatch: "api-int",
+					},
+				},
+			},
+			{
+				IpCidr: "192.168.3.0/28",
+				MonitorPorts: []data.MonitorPort{
+					{
+						Port:      6443,
+						PathMatch: "api",
+					},
+				},
+			},
+		},
+	}
+
+	expected := [][]data.MonitorPort{
+		{
+			{Port: 6443, Name: "api", PathMatch: "api"},
+			{Port: 8443, Name: "ingress-https", PathPrefix: "*.apps"},
+		},
+		{
+			{Port: 6443, Name: "api", PathMatch: "api"},
+			{Port: 22623, Name: "mcs", PathMatch: "api-int"},
+		},
+		{
+			{Port: 6443, PathMatch: "api"},
+		},
+	}
+
+	if err := expandPortProfiles(&monitorConfig); err != nil {
+		t.Fatal(err)
+	}
+	for idx, monitorRange := range monitorConfig.MonitorRanges {
+		if !reflect.DeepEqual(monitorRange.MonitorPorts, expected[idx]) {
+			t.Fatalf("range %d: expected ports %+v, got %+v", idx, expected[idx], monitorRange.MonitorPorts)
+		}
+		if len(monitorRange.PortProfile) > 0 || monitorRange.PortOverrides != nil {
+			t.Fatalf("range %d: port profile should be cleared after expansion", idx)
+		}
+	}
+}
+
+func TestExpandPortProfilesUnknown(t *testing.T) {
+	monitorConfig := data.MonitorConfig{
+		MonitorRanges: []data.MonitorRange{
+			{
+				IpCidr:      "192.168.1.0/28",
+				PortProfile: "missing",
+			},
+		},
+	}
+	if err := expandPortProfiles(&monitorConfig); err == nil {
+		t.Fatal("expected an error for an unknown port profile")
+	}
+}
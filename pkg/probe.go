@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Protocols a port can be probed with. A tcp probe only checks that the port
+// accepts connections, a tls probe completes a TLS handshake without sending
+// anything and http and https probes send a GET request.
+const (
+	ProtocolTCP   = "tcp"
+	ProtocolTLS   = "tls"
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+)
+
+// probeTransport returns an HTTP transport for a single probe. Connections
+// aren't kept alive, so every probe makes a new connection and handshake and
+// sees the certificate the port serves now.
+func probeTransport(sni string) *http.Transport {
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ServerName:         sni,
+		},
+		DisableKeepAlives: true,
+	}
+}
+
+// probeResult is the outcome of a successful probe. Certificates is empty
+// unless the port served TLS.
+type probeResult struct {
+	Certificates []*x509.Certificate
+}
+
+// probe checks an address and port with the given protocol, sending sni during
+// a TLS handshake if it is set.
+func probe(ctx context.Context, protocol, ip string, port int64, sni string, timeout time.Duration) (*probeResult, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	address := net.JoinHostPort(ip, strconv.FormatInt(port, 10))
+
+	switch protocol {
+	case ProtocolTCP:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		conn.Close()
+		return &probeResult{}, nil
+	case ProtocolTLS:
+		dialer := tls.Dialer{
+			Config: &tls.Config{
+				InsecureSkipVerify: true,
+				ServerName:         sni,
+			},
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return &probeResult{
+			Certificates: conn.(*tls.Conn).ConnectionState().PeerCertificates,
+		}, nil
+	case ProtocolHTTP, ProtocolHTTPS:
+		url := fmt.Sprintf("%s://%s", protocol, address)
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := probeTransport(sni).RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		result := &probeResult{}
+		if resp.TLS != nil {
+			result.Certificates = resp.TLS.PeerCertificates
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported protocol %q", protocol)
+}
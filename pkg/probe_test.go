@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	var lock sync.Mutex
+	requests := 0
+	serverNames := []string{}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		lock.Unlock()
+	}))
+	server.TLS = &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			lock.Lock()
+			serverNames = append(serverNames, hello.ServerName)
+			lock.Unlock()
+			return nil, nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	port := func(address string) int64 {
+		_, portStr, _ := net.SplitHostPort(address)
+		port, _ := strconv.ParseInt(portStr, 10, 64)
+		return port
+	}
+	tlsPort := port(server.Listener.Addr().String())
+	tcpPort := port(listener.Addr().String())
+
+	tests := []struct {
+		name             string
+		protocol         string
+		port             int64
+		sni              string
+		expectErr        bool
+		expectCerts      bool
+		expectRequests   int
+		expectServerName string
+	}{
+		{
+			name:     "tcp",
+			protocol: ProtocolTCP,
+			port:     tcpPort,
+		},
+		{
+			name:             "tls with SNI",
+			protocol:         ProtocolTLS,
+			port:             tlsPort,
+			sni:              "api.cluster.example.com",
+			expectCerts:      true,
+			expectServerName: "api.cluster.example.com",
+		},
+		{
+			name:             "https with SNI",
+			protocol:         ProtocolHTTPS,
+			port:             tlsPort,
+			sni:              "test.apps.cluster.example.com",
+			expectCerts:      true,
+			expectRequests:   1,
+			expectServerName: "test.apps.cluster.example.com",
+		},
+		{
+			name:      "tls to a plain port",
+			protocol:  ProtocolTLS,
+			port:      tcpPort,
+			expectErr: true,
+		},
+		{
+			name:      "unsupported protocol",
+			protocol:  "udp",
+			port:      tcpPort,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock.Lock()
+			requests = 0
+			serverNames = []string{}
+			lock.Unlock()
+
+			result, err := probe(context.Background(), tt.protocol, "127.0.0.1", tt.port, tt.sni, time.Second)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.expectCerts != (len(result.Certificates) > 0) {
+				t.Fatalf("expected certificates: %v, got %d", tt.expectCerts, len(result.Certificates))
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+			if requests != tt.expectRequests {
+				t.Fatalf("expected %d requests, got %d", tt.expectRequests, requests)
+			}
+			if len(tt.expectServerName) > 0 && (len(serverNames) != 1 || serverNames[0] != tt.expectServerName) {
+				t.Fatalf("expected server name %s, got %v", tt.expectServerName, serverNames)
+			}
+		})
+	}
+}
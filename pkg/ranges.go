@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/netdata/go.d.plugin/pkg/iprange"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// maxRangeSize is the largest number of addresses a single monitor range may
+// contain.
+const maxRangeSize = 65536
+
+// parseRange returns the addresses described by a monitor range along with the
+// first address of the range. A range is either an ip-cidr, which excludes the
+// network and broadcast addresses, or an ip-address-start and ip-address-end.
+func parseRange(monitorRange *data.MonitorRange) (iprange.Range, netip.Addr, error) {
+	var start netip.Addr
+	var spec string
+
+	if len(monitorRange.IpCidr) > 0 {
+		prefix, err := netip.ParsePrefix(monitorRange.IpCidr)
+		if err != nil {
+			return nil, start, fmt.Errorf("invalid CIDR %q", monitorRange.IpCidr)
+		}
+		start = prefix.Masked().Addr()
+		spec = prefix.Masked().String()
+	} else {
+		var err error
+		start, err = netip.ParseAddr(monitorRange.IpAddressStart)
+		if err != nil {
+			return nil, start, fmt.Errorf("invalid address %q", monitorRange.IpAddressStart)
+		}
+		spec = fmt.Sprintf("%s-%s", monitorRange.IpAddressStart, monitorRange.IpAddressEnd)
+	}
+
+	addrRange, err := iprange.ParseRange(spec)
+	if err != nil || addrRange == nil {
+		return nil, start, fmt.Errorf("invalid range %s", spec)
+	}
+	if addrRange.Size().Cmp(big.NewInt(maxRangeSize)) > 0 {
+		return nil, start, fmt.Errorf("range %s has more than %d addresses", spec, maxRangeSize)
+	}
+
+	// the network address of a CIDR isn't part of the range
+	if !rangeContains(addrRange, start) {
+		start = start.Next()
+	}
+	return addrRange, start, nil
+}
+
+func rangeContains(addrRange iprange.Range, addr netip.Addr) bool {
+	return addrRange.Contains(net.IP(addr.AsSlice()).To16())
+}
+
+// parseExcludes parses the addresses and CIDRs excluded from a monitor range.
+func parseExcludes(excludes []string) ([]netip.Prefix, error) {
+	prefixes := []netip.Prefix{}
+	for _, exclude := range excludes {
+		if strings.Contains(exclude, "/") {
+			prefix, err := netip.ParsePrefix(exclude)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", exclude)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", exclude)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// RangeAddresses returns the addresses in the monitor range which are not
+// excluded.
+func RangeAddresses(monitorRange *data.MonitorRange) ([]netip.Addr, error) {
+	addrRange, start, err := parseRange(monitorRange)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := parseExcludes(monitorRange.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []netip.Addr{}
+	for addr := start; addr.IsValid() && rangeContains(addrRange, addr); addr = addr.Next() {
+		excluded := false
+		for _, exclude := range excludes {
+			if exclude.Contains(addr) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses, nil
+}
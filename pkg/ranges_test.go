@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestRangeAddresses(t *testing.T) {
+	tests := []struct {
+		name         string
+		monitorRange data.MonitorRange
+		expected     []string
+	}{
+		{
+			name: "start and end addresses",
+			monitorRange: data.MonitorRange{
+				IpAddressStart: "192.168.1.254",
+				IpAddressEnd:   "192.168.2.1",
+			},
+			expected: []string{"192.168.1.254", "192.168.1.255", "192.168.2.0", "192.168.2.1"},
+		},
+		{
+			name: "IPv4 CIDR with excludes",
+			monitorRange: data.MonitorRange{
+				IpCidr:  "192.168.151.0/29",
+				Exclude: []string{"192.168.151.2", "192.168.151.4/31"},
+			},
+			expected: []string{"192.168.151.1", "192.168.151.3", "192.168.151.6"},
+		},
+		{
+			name: "IPv6 range",
+			monitorRange: data.MonitorRange{
+				IpAddressStart: "2001:db8::fe",
+				IpAddressEnd:   "2001:db8::101",
+			},
+			expected: []string{"2001:db8::fe", "2001:db8::ff", "2001:db8::100", "2001:db8::101"},
+		},
+		{
+			name: "IPv6 CIDR",
+			monitorRange: data.MonitorRange{
+				IpCidr:  "2001:db8::/126",
+				Exclude: []string{"2001:db8::2"},
+			},
+			expected: []string{"2001:db8::1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addresses, err := RangeAddresses(&tt.monitorRange)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addressStrs := []string{}
+			for _, address := range addresses {
+				addressStrs = append(addressStrs, address.String())
+			}
+			if !reflect.DeepEqual(addressStrs, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, addressStrs)
+			}
+		})
+	}
+}
+
+func TestRangeAddressesTooLarge(t *testing.T) {
+	if _, err := RangeAddresses(&data.MonitorRange{IpCidr: "2001:db8::/64"}); err == nil {
+		t.Fatal("expected an error for a range larger than the maximum size")
+	}
+}
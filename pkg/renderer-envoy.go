@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/go-yaml/yaml"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// EnvoyConfigFile is the name of the Envoy bootstrap among the rendered
+// files.
+const EnvoyConfigFile = "envoy.yaml"
+
+const (
+	envoyTLSInspector = "type.googleapis.com/envoy.extensions.filters.listener.tls_inspector.v3.TlsInspector"
+	envoyTCPProxy     = "type.googleapis.com/envoy.extensions.filters.network.tcp_proxy.v3.TcpProxy"
+	// envoyCheckInterval matches the interval of HAProxy health checks
+	envoyCheckInterval = "2s"
+)
+
+// EnvoyRenderer renders a static Envoy bootstrap with a listener for each
+// listener of the routing table. The TLS inspector reads the server name of
+// each connection and a filter chain matching the names of each route
+// proxies it to the cluster of the route. The HAProxy header is not used.
+type EnvoyRenderer struct{}
+
+type envoyBootstrap struct {
+	StaticResources envoyStaticResources `yaml:"static_resources"`
+}
+
+type envoyStaticResources struct {
+	Listeners []envoyListener `yaml:"listeners"`
+	Clusters  []envoyCluster  `yaml:"clusters"`
+}
+
+type envoySocketAddress struct {
+	Address   string `yaml:"address"`
+	PortValue int64  `yaml:"port_value"`
+}
+
+type envoyAddress struct {
+	SocketAddress envoySocketAddress `yaml:"socket_address"`
+}
+
+type envoyFilter struct {
+	Name        string            `yaml:"name"`
+	TypedConfig map[string]string `yaml:"typed_config"`
+}
+
+type envoyFilterChainMatch struct {
+	ServerNames []string `yaml:"server_names"`
+}
+
+type envoyFilterChain struct {
+	// FilterChainMatch is left out of the chain which matches every
+	// connection
+	FilterChainMatch *envoyFilterChainMatch `yaml:"filter_chain_match,omitempty"`
+	Filters          []envoyFilter          `yaml:"filters"`
+}
+
+type envoyListener struct {
+	Name            string             `yaml:"name"`
+	Address         envoyAddress       `yaml:"address"`
+	ListenerFilters []envoyFilter      `yaml:"listener_filters"`
+	FilterChains    []envoyFilterChain `yaml:"filter_chains"`
+}
+
+type envoyEndpoint struct {
+	Address envoyAddress `yaml:"address"`
+}
+
+type envoyLbEndpoint struct {
+	Endpoint envoyEndpoint `yaml:"endpoint"`
+}
+
+type envoyLocalityLbEndpoints struct {
+	LbEndpoints []envoyLbEndpoint `yaml:"lb_endpoints"`
+}
+
+type envoyLoadAssignment struct {
+	ClusterName string                     `yaml:"cluster_name"`
+	Endpoints   []envoyLocalityLbEndpoints `yaml:"endpoints"`
+}
+
+type envoyHealthCheck struct {
+	Timeout            string   `yaml:"timeout"`
+	Interval           string   `yaml:"interval"`
+	UnhealthyThreshold int      `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int      `yaml:"healthy_threshold"`
+	TCPHealthCheck     struct{} `yaml:"tcp_health_check"`
+}
+
+type envoyCluster struct {
+	Name           string              `yaml:"name"`
+	Type           string              `yaml:"type"`
+	ConnectTimeout string              `yaml:"connect_timeout"`
+	LoadAssignment envoyLoadAssignment `yaml:"load_assignment"`
+	HealthChecks   []envoyHealthCheck  `yaml:"health_checks"`
+}
+
+func (EnvoyRenderer) ConfigFile() string {
+	return EnvoyConfigFile
+}
+
+func (EnvoyRenderer) Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	table := BuildRoutingTable(monitorConfig)
+
+	bootstrap := envoyBootstrap{}
+	routes := []Route{}
+	for _, listener := range table.Listeners {
+		chains := []envoyFilterChain{}
+		for _, route := range listener.exclusiveRoutes() {
+			chain := envoyFilterChain{
+				Filters: []envoyFilter{{
+					Name: "envoy.filters.network.tcp_proxy",
+					TypedConfig: map[string]string{
+						"@type":       envoyTCPProxy,
+						"stat_prefix": route.Name,
+						"cluster":     route.Name,
+					},
+				}},
+			}
+			switch {
+			case len(route.Hosts) > 0:
+				chain.FilterChainMatch = &envoyFilterChainMatch{ServerNames: route.Hosts}
+			case len(route.Suffix) > 0:
+				chain.FilterChainMatch = &envoyFilterChainMatch{ServerNames: []string{"*" + route.Suffix}}
+			}
+			chains = append(chains, chain)
+		}
+
+		// a listener has a single address, so IPv6 connections are accepted
+		// by a listener of their own
+		addresses := []string{"0.0.0.0"}
+		if listener.IPv6 {
+			addresses = append(addresses, "::")
+		}
+		for idx, address := range addresses {
+			name := listener.Name
+			if idx > 0 {
+				name += "-ipv6"
+			}
+			bootstrap.StaticResources.Listeners = append(bootstrap.StaticResources.Listeners, envoyListener{
+				Name:    name,
+				Address: envoyAddress{SocketAddress: envoySocketAddress{Address: address, PortValue: listener.ListenPort()}},
+				ListenerFilters: []envoyFilter{{
+					Name:        "envoy.filters.listener.tls_inspector",
+					TypedConfig: map[string]string{"@type": envoyTLSInspector},
+				}},
+				FilterChains: chains,
+			})
+		}
+		routes = append(routes, listener.Routes...)
+	}
+
+	for _, route := range sortedRoutes(routes) {
+		cluster := envoyCluster{
+			Name:           route.Name,
+			Type:           "STATIC",
+			ConnectTimeout: "10s",
+			HealthChecks: []envoyHealthCheck{{
+				Timeout:            "10s",
+				Interval:           envoyCheckInterval,
+				UnhealthyThreshold: 3,
+				HealthyThreshold:   2,
+			}},
+		}
+		endpoints := envoyLocalityLbEndpoints{}
+		for _, target := range route.Targets {
+			endpoints.LbEndpoints = append(endpoints.LbEndpoints, envoyLbEndpoint{
+				Endpoint: envoyEndpoint{Address: envoyAddress{SocketAddress: envoySocketAddress{Address: target, PortValue: route.Port}}},
+			})
+		}
+		cluster.LoadAssignment = envoyLoadAssignment{ClusterName: route.Name, Endpoints: []envoyLocalityLbEndpoints{endpoints}}
+		bootstrap.StaticResources.Clusters = append(bootstrap.StaticResources.Clusters, cluster)
+	}
+
+	content, err := yaml.Marshal(&bootstrap)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to render the Envoy bootstrap: %v", err)
+	}
+	hash, err := hashRoutingTable(RendererEnvoy, "", table)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string]string{EnvoyConfigFile: string(content)}, hash, nil
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// NginxStreamConfigFile is the name of the nginx configuration among the
+// rendered files.
+const NginxStreamConfigFile = "nginx.conf"
+
+// NginxStreamRenderer renders an nginx configuration whose stream block reads
+// the server name of each connection with ssl_preread and looks up the
+// upstream of its cluster in a map for each listener. The HAProxy header is
+// written before the stream block, so it holds the rest of nginx.conf, such
+// as the events block.
+type NginxStreamRenderer struct{}
+
+func (NginxStreamRenderer) ConfigFile() string {
+	return NginxStreamConfigFile
+}
+
+func (NginxStreamRenderer) Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	table := BuildRoutingTable(monitorConfig)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(monitorConfig.HaproxyHeader)
+	buf.WriteString("stream {\n")
+	upstreams := []Route{}
+	for _, listener := range table.Listeners {
+		// nginx variables can't hold a hyphen
+		variable := "$" + strings.ReplaceAll(listener.Name, "-", "_")
+
+		// nginx tries exact names before wildcards, longest first, so the
+		// order of the routes is kept
+		fmt.Fprintf(buf, "    map $ssl_preread_server_name %s {\n", variable)
+		fmt.Fprintf(buf, "        hostnames;\n")
+		for _, route := range listener.exclusiveRoutes() {
+			switch {
+			case len(route.Hosts) > 0:
+				for _, host := range route.Hosts {
+					fmt.Fprintf(buf, "        %s %s;\n", strings.ToLower(host), route.Name)
+				}
+			case len(route.Suffix) > 0:
+				fmt.Fprintf(buf, "        *%s %s;\n", strings.ToLower(route.Suffix), route.Name)
+			default:
+				fmt.Fprintf(buf, "        default %s;\n", route.Name)
+			}
+		}
+		fmt.Fprintf(buf, "    }\n\n")
+
+		fmt.Fprintf(buf, "    server {\n")
+		fmt.Fprintf(buf, "        listen %d;\n", listener.ListenPort())
+		if listener.IPv6 {
+			fmt.Fprintf(buf, "        listen [::]:%d ipv6only=on;\n", listener.ListenPort())
+		}
+		fmt.Fprintf(buf, "        ssl_preread on;\n")
+		fmt.Fprintf(buf, "        proxy_pass %s;\n", variable)
+		fmt.Fprintf(buf, "    }\n\n")
+
+		upstreams = append(upstreams, listener.Routes...)
+	}
+	for _, route := range sortedRoutes(upstreams) {
+		fmt.Fprintf(buf, "    upstream %s {\n", route.Name)
+		for _, target := range route.Targets {
+			fmt.Fprintf(buf, "        server %s;\n", net.JoinHostPort(target, strconv.FormatInt(route.Port, 10)))
+		}
+		fmt.Fprintf(buf, "    }\n\n")
+	}
+	// the last block isn't followed by a blank line
+	buf.Truncate(len(bytes.TrimRight(buf.Bytes(), "\n")) + 1)
+	buf.WriteString("}\n")
+
+	hash, err := hashRoutingTable(RendererNginxStream, monitorConfig.HaproxyHeader, table)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string]string{NginxStreamConfigFile: buf.String()}, hash, nil
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// TraefikConfigFile is the name of the Traefik dynamic configuration among
+// the rendered files.
+const TraefikConfigFile = "traefik.yaml"
+
+// TraefikRenderer renders a dynamic configuration for the Traefik file
+// provider with a TCP router passing the TLS connections of each route
+// through to a service of its targets. Entry points can only be declared in
+// the static configuration, so one named after each listener, such as
+// dynaconfig-fe-6443 on :16443, must be declared there. The rules use the
+// Traefik v3 syntax and the HAProxy header is not used.
+type TraefikRenderer struct{}
+
+type traefikTLS struct {
+	Passthrough bool `yaml:"passthrough"`
+}
+
+type traefikRouter struct {
+	EntryPoints []string   `yaml:"entryPoints"`
+	Rule        string     `yaml:"rule"`
+	Service     string     `yaml:"service"`
+	Priority    int        `yaml:"priority"`
+	TLS         traefikTLS `yaml:"tls"`
+}
+
+type traefikServer struct {
+	Address string `yaml:"address"`
+}
+
+type traefikLoadBalancer struct {
+	Servers []traefikServer `yaml:"servers"`
+}
+
+type traefikService struct {
+	LoadBalancer traefikLoadBalancer `yaml:"loadBalancer"`
+}
+
+type traefikTCP struct {
+	Routers  map[string]traefikRouter  `yaml:"routers"`
+	Services map[string]traefikService `yaml:"services"`
+}
+
+type traefikConfig struct {
+	TCP traefikTCP `yaml:"tcp"`
+}
+
+func (TraefikRenderer) ConfigFile() string {
+	return TraefikConfigFile
+}
+
+func (TraefikRenderer) Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	table := BuildRoutingTable(monitorConfig)
+
+	config := traefikConfig{TCP: traefikTCP{
+		Routers:  map[string]traefikRouter{},
+		Services: map[string]traefikService{},
+	}}
+	for _, listener := range table.Listeners {
+		routes := listener.exclusiveRoutes()
+		for idx, route := range routes {
+			rules := []string{}
+			switch {
+			case len(route.Hosts) > 0:
+				for _, host := range route.Hosts {
+					rules = append(rules, fmt.Sprintf("HostSNI(`%s`)", host))
+				}
+			case len(route.Suffix) > 0:
+				rules = append(rules, fmt.Sprintf("HostSNIRegexp(`^.+%s$`)", regexp.QuoteMeta(route.Suffix)))
+			default:
+				rules = append(rules, "HostSNI(`*`)")
+			}
+			// Traefik tries the longest rule first unless priorities are
+			// set, so they keep the most specific route first
+			config.TCP.Routers[route.Name] = traefikRouter{
+				EntryPoints: []string{listener.Name},
+				Rule:        strings.Join(rules, " || "),
+				Service:     route.Name,
+				Priority:    len(routes) - idx,
+				TLS:         traefikTLS{Passthrough: true},
+			}
+		}
+		for _, route := range listener.Routes {
+			service := traefikService{}
+			for _, target := range route.Targets {
+				service.LoadBalancer.Servers = append(service.LoadBalancer.Servers, traefikServer{
+					Address: net.JoinHostPort(target, strconv.FormatInt(route.Port, 10)),
+				})
+			}
+			config.TCP.Services[route.Name] = service
+		}
+	}
+
+	content, err := yaml.Marshal(&config)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to render the Traefik configuration: %v", err)
+	}
+	hash, err := hashRoutingTable(RendererTraefik, "", table)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string]string{TraefikConfigFile: string(content)}, hash, nil
+}
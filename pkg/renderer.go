@@ -0,0 +1,254 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Renderers name the proxies the routing table can be rendered for.
+const (
+	RendererHAProxy     = "haproxy"
+	RendererNginxStream = "nginx-stream"
+	RendererEnvoy       = "envoy"
+	RendererTraefik     = "traefik"
+)
+
+// listenerPortOffset is added to a monitored port to give the port its
+// listener accepts connections on.
+const listenerPortOffset = 10000
+
+// Renderer renders the routing table of a monitor config as the
+// configuration of a proxy.
+type Renderer interface {
+	// ConfigFile is the name of the configuration among the rendered files.
+	// The other files are read by the configuration, such as HAProxy maps.
+	ConfigFile() string
+	// Render returns the rendered files keyed by file name and a hash which
+	// only changes when the routing does.
+	Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error)
+}
+
+var renderers = map[string]Renderer{
+	RendererHAProxy:     HAProxyRenderer{},
+	RendererNginxStream: NginxStreamRenderer{},
+	RendererEnvoy:       EnvoyRenderer{},
+	RendererTraefik:     TraefikRenderer{},
+}
+
+// NewRenderer returns the renderer chosen by the output config, HAProxy
+// unless it is set.
+func NewRenderer(config data.OutputConfig) (Renderer, error) {
+	if len(config.Renderer) == 0 {
+		return HAProxyRenderer{}, nil
+	}
+	renderer, exists := renderers[config.Renderer]
+	if !exists {
+		return nil, fmt.Errorf("unknown renderer %q", config.Renderer)
+	}
+	return renderer, nil
+}
+
+// Render renders the files of the monitor config with the renderer chosen
+// by its output, along with their hash.
+func Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	renderer, err := NewRenderer(monitorConfig.Output)
+	if err != nil {
+		return nil, "", err
+	}
+	return renderer.Render(monitorConfig)
+}
+
+// HAProxyRenderer renders the HAProxy configuration, which starts with the
+// HAProxy header, and its map files.
+type HAProxyRenderer struct{}
+
+func (HAProxyRenderer) ConfigFile() string {
+	return HAProxyConfigFile
+}
+
+func (HAProxyRenderer) Render(monitorConfig *data.MonitorConfig) (map[string]string, string, error) {
+	return BuildTargetHAProxyFiles(monitorConfig)
+}
+
+// Route sends the connections to a listener whose server name matches to the
+// targets of a port of a cluster. A route which matches neither hosts nor a
+// suffix matches every connection.
+type Route struct {
+	// Name is the name of the backend of the route, the base domain and the
+	// port
+	Name string
+	// Hosts are matched exactly
+	Hosts []string
+	// Suffix is matched against the end of the server name
+	Suffix string
+	// Port is the port of the targets
+	Port int64
+	// Targets are the addresses of the cluster, ordered by address
+	Targets []string
+}
+
+// rule returns the backend switching rule of the route.
+func (r *Route) rule() *backendSwitchingRule {
+	return &backendSwitchingRule{backend: r.Name, hosts: r.Hosts, suffix: r.Suffix}
+}
+
+// Listener accepts the connections to a monitored port, on the port plus
+// 10000, and routes them by server name.
+type Listener struct {
+	Name string
+	// Port is the monitored port
+	Port int64
+	// IPv6 is set if the listener also accepts connections over IPv6
+	IPv6 bool
+	// Routes are ordered most specific first
+	Routes []Route
+}
+
+// ListenPort is the port the listener accepts connections on.
+func (l *Listener) ListenPort() int64 {
+	return listenerPortOffset + l.Port
+}
+
+// exclusiveRoutes returns the routes of the listener without the names
+// matched by a route before them, for proxies which refuse to match a name
+// twice. Only the first route which matches every connection is kept.
+func (l *Listener) exclusiveRoutes() []Route {
+	routes := []Route{}
+	claimed := map[string]bool{}
+	for _, route := range l.Routes {
+		switch {
+		case len(route.Hosts) > 0:
+			hosts := []string{}
+			for _, host := range route.Hosts {
+				if !claimed[strings.ToLower(host)] {
+					claimed[strings.ToLower(host)] = true
+					hosts = append(hosts, host)
+				}
+			}
+			if len(hosts) == 0 {
+				continue
+			}
+			route.Hosts = hosts
+		case len(route.Suffix) > 0:
+			if claimed["*"+strings.ToLower(route.Suffix)] {
+				continue
+			}
+			claimed["*"+strings.ToLower(route.Suffix)] = true
+		default:
+			if claimed["*"] {
+				continue
+			}
+			claimed["*"] = true
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// hashRoutingTable hashes the routing table rendered by a renderer along with
+// the normalized header, as ConfigurationHash does for HAProxy. The same table
+// hashes differently for each renderer.
+func hashRoutingTable(renderer, header string, table *RoutingTable) (string, error) {
+	normalized, err := json.Marshal(struct {
+		Header   []string
+		Renderer string
+		Table    *RoutingTable
+	}{normalizedHeader(header), renderer, table})
+	if err != nil {
+		return "", fmt.Errorf("unable to normalize the routing table: %v", err)
+	}
+	return util.GenerateSHA512Hash(normalized), nil
+}
+
+// sortedRoutes returns a copy of the routes ordered by name.
+func sortedRoutes(routes []Route) []Route {
+	sorted := append([]Route{}, routes...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Name < sorted[b].Name
+	})
+	return sorted
+}
+
+// RoutingTable is how connections are routed to the clusters found, whatever
+// proxy it is rendered for.
+type RoutingTable struct {
+	// Listeners are ordered by name
+	Listeners []Listener
+}
+
+// BuildRoutingTable builds a listener for each monitored port and a route for
+// each port of each cluster found in the monitor config. The same clusters
+// always build the same table whatever order they were found in.
+func BuildRoutingTable(monitorConfig *data.MonitorConfig) *RoutingTable {
+	listeners := map[string]*Listener{}
+	ipv6 := ipv6Ports(monitorConfig)
+
+	clusters, _ := DiscoveredClusters(monitorConfig.MonitorRanges, monitorConfig.ConflictResolution)
+	for _, monitorRange := range clusters {
+		for _, monitorPort := range monitorRange.MonitorPorts {
+			if len(monitorPort.Targets) == 0 || len(monitorRange.BaseDomain) == 0 {
+				continue
+			}
+			listenerName := fmt.Sprintf("dynaconfig-fe-%d", monitorPort.Port)
+			listener, exists := listeners[listenerName]
+			if !exists {
+				listener = &Listener{Name: listenerName, Port: monitorPort.Port, IPv6: ipv6[monitorPort.Port]}
+				listeners[listenerName] = listener
+			}
+
+			route := Route{
+				Name:    fmt.Sprintf("%s-%d", monitorRange.BaseDomain, monitorPort.Port),
+				Port:    monitorPort.Port,
+				Targets: append([]string{}, monitorPort.Targets...),
+			}
+			route.Hosts, route.Suffix = routeMatch(monitorRange.BaseDomain, &monitorPort)
+			sort.SliceStable(route.Targets, func(a, b int) bool {
+				return util.AddressLess(route.Targets[a], route.Targets[b])
+			})
+			logrus.Debugf("creating route %s on %s", route.Name, listenerName)
+			listener.Routes = append(listener.Routes, route)
+		}
+	}
+
+	table := &RoutingTable{}
+	for _, listener := range listeners {
+		sort.SliceStable(listener.Routes, func(a, b int) bool {
+			return backendSwitchingRuleLess(listener.Routes[a].rule(), listener.Routes[b].rule())
+		})
+		table.Listeners = append(table.Listeners, *listener)
+	}
+	sort.Slice(table.Listeners, func(a, b int) bool {
+		return table.Listeners[a].Name < table.Listeners[b].Name
+	})
+	return table
+}
+
+// routeMatch returns the names a port of a cluster is routed for. A port
+// with a path match matches the host named by it exactly, along with api-int
+// for the API. A port with a path prefix matches the names under it, such as
+// *.apps.<domain> for ingress.
+func routeMatch(baseDomain string, port *data.MonitorPort) ([]string, string) {
+	switch {
+	case len(port.PathMatch) > 0:
+		label := strings.TrimSuffix(port.PathMatch, ".")
+		hosts := []string{label + "." + baseDomain}
+		if label == apiLabel {
+			hosts = append(hosts, apiLabel+"-int."+baseDomain)
+		}
+		return hosts, ""
+	case len(port.PathPrefix) > 0:
+		prefix := strings.Trim(strings.TrimPrefix(port.PathPrefix, "*"), ".")
+		suffix := "." + baseDomain
+		if len(prefix) > 0 {
+			suffix = "." + prefix + suffix
+		}
+		return nil, suffix
+	}
+	return nil, ""
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func rendererConfig(renderer string) *data.MonitorConfig {
+	return &data.MonitorConfig{
+		HaproxyHeader: "events {}\n",
+		Output:        data.OutputConfig{Renderer: renderer},
+		MonitorRanges: []data.MonitorRange{
+			{
+				BaseDomain: "a.example.com",
+				MonitorPorts: []data.MonitorPort{
+					{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.5", "192.168.1.4"}},
+					{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.4"}},
+				},
+			},
+			{
+				BaseDomain:   "b.example.com",
+				MonitorPorts: []data.MonitorPort{{Port: 443, PathPrefix: "*.apps", Targets: []string{"fd00::5"}}},
+			},
+		},
+	}
+}
+
+const goodNginxStream = `events {}
+stream {
+    map $ssl_preread_server_name $dynaconfig_fe_443 {
+        hostnames;
+        *.apps.a.example.com a.example.com-443;
+        *.apps.b.example.com b.example.com-443;
+    }
+
+    server {
+        listen 10443;
+        listen [::]:10443 ipv6only=on;
+        ssl_preread on;
+        proxy_pass $dynaconfig_fe_443;
+    }
+
+    map $ssl_preread_server_name $dynaconfig_fe_6443 {
+        hostnames;
+        api.a.example.com a.example.com-6443;
+        api-int.a.example.com a.example.com-6443;
+    }
+
+    server {
+        listen 16443;
+        ssl_preread on;
+        proxy_pass $dynaconfig_fe_6443;
+    }
+
+    upstream a.example.com-443 {
+        server 192.168.1.4:443;
+    }
+
+    upstream a.example.com-6443 {
+        server 192.168.1.4:6443;
+        server 192.168.1.5:6443;
+    }
+
+    upstream b.example.com-443 {
+        server [fd00::5]:443;
+    }
+}
+`
+
+func TestRenderNginxStream(t *testing.T) {
+	files, _, err := Render(rendererConfig(RendererNginxStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMatch(t, files[NginxStreamConfigFile], goodNginxStream)
+}
+
+func TestRenderEnvoy(t *testing.T) {
+	files, _, err := Render(rendererConfig(RendererEnvoy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrap := envoyBootstrap{}
+	if err := yaml.Unmarshal([]byte(files[EnvoyConfigFile]), &bootstrap); err != nil {
+		t.Fatal(err)
+	}
+
+	listeners := []string{}
+	for _, listener := range bootstrap.StaticResources.Listeners {
+		listeners = append(listeners, listener.Name+" "+listener.Address.SocketAddress.Address)
+		if len(listener.ListenerFilters) != 1 || listener.ListenerFilters[0].TypedConfig["@type"] != envoyTLSInspector {
+			t.Fatalf("expected %s to inspect the TLS hello, got %v", listener.Name, listener.ListenerFilters)
+		}
+	}
+	if strings.Join(listeners, ",") != "dynaconfig-fe-443 0.0.0.0,dynaconfig-fe-443-ipv6 ::,dynaconfig-fe-6443 0.0.0.0" {
+		t.Fatalf("unexpected listeners %v", listeners)
+	}
+
+	api := bootstrap.StaticResources.Listeners[2]
+	if api.Address.SocketAddress.PortValue != 16443 || len(api.FilterChains) != 1 ||
+		strings.Join(api.FilterChains[0].FilterChainMatch.ServerNames, ",") != "api.a.example.com,api-int.a.example.com" ||
+		api.FilterChains[0].Filters[0].TypedConfig["cluster"] != "a.example.com-6443" {
+		t.Fatalf("expected the API hosts to be proxied to their cluster, got %+v", api)
+	}
+	if names := bootstrap.StaticResources.Listeners[0].FilterChains[1].FilterChainMatch.ServerNames; len(names) != 1 || names[0] != "*.apps.b.example.com" {
+		t.Fatalf("expected the ingress to match a wildcard, got %v", names)
+	}
+
+	clusters := bootstrap.StaticResources.Clusters
+	if len(clusters) != 3 || clusters[1].Name != "a.example.com-6443" || len(clusters[1].LoadAssignment.Endpoints[0].LbEndpoints) != 2 ||
+		clusters[2].LoadAssignment.Endpoints[0].LbEndpoints[0].Endpoint.Address.SocketAddress.Address != "fd00::5" {
+		t.Fatalf("unexpected clusters %+v", clusters)
+	}
+}
+
+func TestRenderTraefik(t *testing.T) {
+	files, _, err := Render(rendererConfig(RendererTraefik))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := traefikConfig{}
+	if err := yaml.Unmarshal([]byte(files[TraefikConfigFile]), &config); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]traefikRouter{
+		"a.example.com-6443": {
+			EntryPoints: []string{"dynaconfig-fe-6443"},
+			Rule:        "HostSNI(`api.a.example.com`) || HostSNI(`api-int.a.example.com`)",
+			Priority:    1,
+		},
+		"a.example.com-443": {
+			EntryPoints: []string{"dynaconfig-fe-443"},
+			Rule:        "HostSNIRegexp(`^.+\\.apps\\.a\\.example\\.com$`)",
+			Priority:    2,
+		},
+		"b.example.com-443": {
+			EntryPoints: []string{"dynaconfig-fe-443"},
+			Rule:        "HostSNIRegexp(`^.+\\.apps\\.b\\.example\\.com$`)",
+			Priority:    1,
+		},
+	}
+	if len(config.TCP.Routers) != len(expected) {
+		t.Fatalf("expected %d routers, got %v", len(expected), config.TCP.Routers)
+	}
+	for name, router := range expected {
+		rendered := config.TCP.Routers[name]
+		if rendered.Rule != router.Rule || rendered.Priority != router.Priority || rendered.Service != name ||
+			strings.Join(rendered.EntryPoints, ",") != router.EntryPoints[0] || !rendered.TLS.Passthrough {
+			t.Fatalf("expected router %s %+v, got %+v", name, router, rendered)
+		}
+	}
+	if servers := config.TCP.Services["b.example.com-443"].LoadBalancer.Servers; len(servers) != 1 || servers[0].Address != "[fd00::5]:443" {
+		t.Fatalf("unexpected servers %v", servers)
+	}
+}
+
+func TestRenderHash(t *testing.T) {
+	// the nginx header isn't a valid HAProxy header
+	config := func(renderer string) *data.MonitorConfig {
+		config := rendererConfig(renderer)
+		config.HaproxyHeader = ""
+		return config
+	}
+	hashes := map[string]string{}
+	for _, renderer := range []string{RendererHAProxy, RendererNginxStream, RendererEnvoy, RendererTraefik} {
+		_, hash, err := Render(config(renderer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, exists := hashes[hash]; exists {
+			t.Fatalf("expected %s and %s to have different hashes", renderer, other)
+		}
+		hashes[hash] = renderer
+
+		// a changed target changes the hash
+		changed := config(renderer)
+		changed.MonitorRanges[1].MonitorPorts[0].Targets = []string{"fd00::6"}
+		if _, changedHash, _ := Render(changed); changedHash == hash {
+			t.Fatalf("expected a changed target to change the %s hash", renderer)
+		}
+	}
+
+	if _, _, err := Render(rendererConfig("caddy")); err == nil {
+		t.Fatal("expected an unknown renderer to fail")
+	}
+}
+
+func TestExclusiveRoutes(t *testing.T) {
+	listener := Listener{Routes: []Route{
+		{Name: "a", Hosts: []string{"api.example.com", "api-int.example.com"}},
+		{Name: "b", Hosts: []string{"API.example.com"}},
+		{Name: "c", Suffix: ".apps.example.com"},
+		{Name: "d", Suffix: ".apps.example.com"},
+		{Name: "e"},
+		{Name: "f"},
+	}}
+	routes := listener.exclusiveRoutes()
+	names := []string{}
+	for _, route := range routes {
+		names = append(names, route.Name)
+	}
+	if strings.Join(names, ",") != "a,c,e" {
+		t.Fatalf("expected names matched twice to be dropped, got %v", names)
+	}
+}
@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+	"github.com/sirupsen/logrus"
+)
+
+// runtimeAPITimeout bounds the time waiting for HAProxy to answer a command.
+const runtimeAPITimeout = 10 * time.Second
+
+// serverAdminForcedMaintenance is the bit of the admin state of a server set
+// by the disable server command and by the disabled keyword.
+const serverAdminForcedMaintenance = 0x01
+
+// runtimeAPIErrors start the responses of commands which failed.
+var runtimeAPIErrors = []string{
+	"Unknown command",
+	"No such",
+	"Can't find",
+	"Require",
+	"Permission denied",
+	"Invalid",
+	"Missing",
+}
+
+// RuntimeAPI sends commands to the HAProxy Runtime API of a stats socket with
+// the admin level.
+type RuntimeAPI struct {
+	// Address is the path of a unix socket or the host and port of a TCP
+	// socket
+	Address string
+}
+
+// NewRuntimeAPI returns the Runtime API of the config, or nil if the config
+// doesn't use one.
+func NewRuntimeAPI(config data.RuntimeAPIConfig) *RuntimeAPI {
+	if len(config.Address) == 0 {
+		return nil
+	}
+	return &RuntimeAPI{Address: config.Address}
+}
+
+// RuntimeServer is the state of a server of a running HAProxy.
+type RuntimeServer struct {
+	Backend string
+	Name    string
+	Address string
+	Port    int64
+	// Disabled is set if the server is in forced maintenance
+	Disabled bool
+}
+
+// Execute sends a command and returns the response. Each command is sent on
+// its own connection.
+func (r *RuntimeAPI) Execute(command string) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(r.Address, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, r.Address, runtimeAPITimeout)
+	if err != nil {
+		return "", fmt.Errorf("unable to connect to the runtime API: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(runtimeAPITimeout))
+
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", fmt.Errorf("unable to send %q: %v", command, err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("unable to read the response to %q: %v", command, err)
+	}
+	trimmed := strings.TrimSpace(string(response))
+	for _, prefix := range runtimeAPIErrors {
+		if strings.HasPrefix(trimmed, prefix) {
+			return "", fmt.Errorf("%q failed: %s", command, trimmed)
+		}
+	}
+	return string(response), nil
+}
+
+// ServersState returns the servers of every backend.
+func (r *RuntimeAPI) ServersState() ([]RuntimeServer, error) {
+	response, err := r.Execute("show servers state")
+	if err != nil {
+		return nil, err
+	}
+
+	// the first line is the version of the format and the second names the
+	// columns
+	lines := strings.Split(strings.TrimSpace(response), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "#") {
+		return nil, fmt.Errorf("unexpected servers state: %q", response)
+	}
+	columns := map[string]int{}
+	for idx, name := range strings.Fields(strings.TrimPrefix(lines[1], "#")) {
+		columns[name] = idx
+	}
+	for _, name := range []string{"be_name", "srv_name", "srv_addr", "srv_admin_state", "srv_port"} {
+		if _, exists := columns[name]; !exists {
+			return nil, fmt.Errorf("servers state has no %s column", name)
+		}
+	}
+
+	servers := []RuntimeServer{}
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != len(columns) {
+			continue
+		}
+		adminState, err := strconv.Atoi(fields[columns["srv_admin_state"]])
+		if err != nil {
+			return nil, fmt.Errorf("invalid admin state of %s/%s: %v", fields[columns["be_name"]], fields[columns["srv_name"]], err)
+		}
+		port, err := strconv.ParseInt(fields[columns["srv_port"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of %s/%s: %v", fields[columns["be_name"]], fields[columns["srv_name"]], err)
+		}
+		servers = append(servers, RuntimeServer{
+			Backend:  fields[columns["be_name"]],
+			Name:     fields[columns["srv_name"]],
+			Address:  fields[columns["srv_addr"]],
+			Port:     port,
+			Disabled: adminState&serverAdminForcedMaintenance != 0,
+		})
+	}
+	return servers, nil
+}
+
+// SetServerAddress points a server at an address and port.
+func (r *RuntimeAPI) SetServerAddress(backend, server, address string, port int64) error {
+	_, err := r.Execute(fmt.Sprintf("set server %s/%s addr %s port %d", backend, server, address, port))
+	return err
+}
+
+// EnableServer takes a server out of maintenance.
+func (r *RuntimeAPI) EnableServer(backend, server string) error {
+	_, err := r.Execute(fmt.Sprintf("enable server %s/%s", backend, server))
+	return err
+}
+
+// DisableServer puts a server into maintenance.
+func (r *RuntimeAPI) DisableServer(backend, server string) error {
+	_, err := r.Execute(fmt.Sprintf("disable server %s/%s", backend, server))
+	return err
+}
+
+// ApplyServers makes the servers of the running HAProxy serve the targets of
+// the backends of the config which have server slots. Servers already serving
+// a target are left alone, targets which are gone are disabled and new
+// targets are moved into free slots, which are enabled. An error is returned
+// if a backend is not running or has no free slot left, in which case HAProxy
+// must be reloaded.
+func (r *RuntimeAPI) ApplyServers(config *haproxy.Config) error {
+	state, err := r.ServersState()
+	if err != nil {
+		return err
+	}
+	running := map[string][]RuntimeServer{}
+	for _, server := range state {
+		running[server.Backend] = append(running[server.Backend], server)
+	}
+
+	for _, backend := range config.Backends {
+		if len(backend.ServerTemplates) == 0 {
+			continue
+		}
+		servers, exists := running[backend.Name]
+		if !exists {
+			return fmt.Errorf("backend %s is not running", backend.Name)
+		}
+		if err := r.applyBackend(backend, servers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RuntimeAPI) applyBackend(backend *haproxy.Backend, servers []RuntimeServer) error {
+	runtimeKey := func(address string, port int64) string {
+		return net.JoinHostPort(address, strconv.FormatInt(port, 10))
+	}
+	desired := map[string]bool{}
+	for _, server := range backend.Servers {
+		desired[runtimeKey(server.Address, server.Port)] = true
+	}
+
+	served := map[string]bool{}
+	free := []RuntimeServer{}
+	stale := []RuntimeServer{}
+	for _, server := range servers {
+		key := runtimeKey(server.Address, server.Port)
+		switch {
+		case desired[key] && !served[key]:
+			served[key] = true
+			if server.Disabled {
+				if err := r.EnableServer(backend.Name, server.Name); err != nil {
+					return err
+				}
+				runtimeServerUpdates.WithLabelValues("enabled").Inc()
+			}
+		case server.Disabled:
+			free = append(free, server)
+		default:
+			stale = append(stale, server)
+		}
+	}
+
+	// new targets take free slots first and then those of stale targets, so
+	// a slot is only out of service if there is nothing left for it to serve
+	for _, target := range backend.Servers {
+		key := runtimeKey(target.Address, target.Port)
+		if served[key] {
+			continue
+		}
+		served[key] = true
+		var slot RuntimeServer
+		switch {
+		case len(free) > 0:
+			slot, free = free[0], free[1:]
+		case len(stale) > 0:
+			slot, stale = stale[0], stale[1:]
+		default:
+			return fmt.Errorf("backend %s has no free server slot for %s", backend.Name, key)
+		}
+		logrus.Infof("moving %s into server %s/%s", key, backend.Name, slot.Name)
+		if err := r.SetServerAddress(backend.Name, slot.Name, target.Address, target.Port); err != nil {
+			return err
+		}
+		if slot.Disabled {
+			if err := r.EnableServer(backend.Name, slot.Name); err != nil {
+				return err
+			}
+		}
+		runtimeServerUpdates.WithLabelValues("added").Inc()
+	}
+
+	for _, server := range stale {
+		logrus.Infof("disabling server %s/%s, %s is no longer a target", backend.Name, server.Name, runtimeKey(server.Address, server.Port))
+		if err := r.DisableServer(backend.Name, server.Name); err != nil {
+			return err
+		}
+		runtimeServerUpdates.WithLabelValues("removed").Inc()
+	}
+	return nil
+}
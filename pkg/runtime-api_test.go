@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+)
+
+// fakeRuntimeAPI answers the Runtime API commands used to update servers
+// from the servers it holds.
+type fakeRuntimeAPI struct {
+	lock     sync.Mutex
+	servers  []RuntimeServer
+	commands []string
+}
+
+func startFakeRuntimeAPI(t *testing.T, servers []RuntimeServer) (*fakeRuntimeAPI, *RuntimeAPI) {
+	socketPath := filepath.Join(t.TempDir(), "haproxy.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	fake := &fakeRuntimeAPI{servers: servers}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte(fake.execute(strings.TrimSpace(command))))
+			conn.Close()
+		}
+	}()
+	return fake, &RuntimeAPI{Address: socketPath}
+}
+
+func (f *fakeRuntimeAPI) execute(command string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if command == "show servers state" {
+		state := "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state srv_uweight srv_iweight srv_time_since_last_change srv_check_status srv_check_result srv_check_health srv_check_state srv_agent_state bk_f_forced_id srv_f_forced_id srv_fqdn srv_port srvrecord\n"
+		for idx, server := range f.servers {
+			adminState := 0
+			if server.Disabled {
+				adminState = 5
+			}
+			state += fmt.Sprintf("3 %s %d %s %s 2 %d 1 1 10 6 3 4 6 0 0 0 - %d -\n",
+				server.Backend, idx+1, server.Name, server.Address, adminState, server.Port)
+		}
+		return state + "\n"
+	}
+
+	f.commands = append(f.commands, command)
+	fields := strings.Fields(command)
+	if len(fields) < 3 || fields[1] != "server" {
+		return "Unknown command. Please enter one of the following commands only :\n"
+	}
+	var server *RuntimeServer
+	for idx := range f.servers {
+		if f.servers[idx].Backend+"/"+f.servers[idx].Name == fields[2] {
+			server = &f.servers[idx]
+		}
+	}
+	if server == nil {
+		return "No such server.\n"
+	}
+	switch {
+	case fields[0] == "enable":
+		server.Disabled = false
+	case fields[0] == "disable":
+		server.Disabled = true
+	case fields[0] == "set" && len(fields) == 7 && fields[3] == "addr":
+		server.Address = fields[4]
+		server.Port, _ = strconv.ParseInt(fields[6], 10, 64)
+		return "IP changed\n"
+	default:
+		return "Unknown command. Please enter one of the following commands only :\n"
+	}
+	return "\n"
+}
+
+func (f *fakeRuntimeAPI) state() ([]RuntimeServer, []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]RuntimeServer{}, f.servers...), append([]string{}, f.commands...)
+}
+
+func runtimeModel(t *testing.T, targets ...string) *haproxy.Config {
+	config, _, err := BuildDynamicModel(&data.MonitorConfig{
+		RuntimeAPI: data.RuntimeAPIConfig{Address: "/var/run/haproxy.sock", ServerSlots: 4},
+		MonitorRanges: []data.MonitorRange{{
+			BaseDomain:   "cluster.example.com",
+			MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api", Targets: targets}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestRuntimeAPIApplyServers(t *testing.T) {
+	const backend = "cluster.example.com-6443"
+	slot := func(name, address string, disabled bool) RuntimeServer {
+		return RuntimeServer{Backend: backend, Name: name, Address: address, Port: 6443, Disabled: disabled}
+	}
+	fake, runtimeAPI := startFakeRuntimeAPI(t, []RuntimeServer{
+		slot("slot1", "192.168.1.4", false),
+		slot("slot2", "192.168.1.5", false),
+		slot("slot3", "0.0.0.0", true),
+		slot("slot4", "192.168.1.8", true),
+	})
+
+	// a target which is kept is left alone, a removed one is disabled and
+	// new ones take the free slots
+	if err := runtimeAPI.ApplyServers(runtimeModel(t, "192.168.1.4", "192.168.1.6", "192.168.1.7")); err != nil {
+		t.Fatal(err)
+	}
+	servers, commands := fake.state()
+	expected := []RuntimeServer{
+		slot("slot1", "192.168.1.4", false),
+		slot("slot2", "192.168.1.5", true),
+		slot("slot3", "192.168.1.6", false),
+		slot("slot4", "192.168.1.7", false),
+	}
+	for idx := range expected {
+		if servers[idx] != expected[idx] {
+			t.Fatalf("expected %v, got %v", expected[idx], servers[idx])
+		}
+	}
+	for _, command := range commands {
+		if strings.Contains(command, "/slot1") {
+			t.Fatalf("expected the kept target not to be touched, got %q", command)
+		}
+	}
+
+	// applying the same targets again changes nothing
+	if err := runtimeAPI.ApplyServers(runtimeModel(t, "192.168.1.4", "192.168.1.6", "192.168.1.7")); err != nil {
+		t.Fatal(err)
+	}
+	if _, repeated := fake.state(); len(repeated) != len(commands) {
+		t.Fatalf("expected no commands, got %v", repeated[len(commands):])
+	}
+
+	// a disabled slot is reused, and slots of removed targets are used once
+	// there are none left
+	if err := runtimeAPI.ApplyServers(runtimeModel(t, "192.168.1.4", "192.168.1.6", "192.168.1.9")); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := fake.state(); servers[1] != slot("slot2", "192.168.1.9", false) || !servers[3].Disabled {
+		t.Fatalf("expected 192.168.1.9 to take the disabled slot, got %v", servers)
+	}
+	err := runtimeAPI.ApplyServers(runtimeModel(t, "192.168.1.10", "192.168.1.11", "192.168.1.12", "192.168.1.13", "192.168.1.14"))
+	if err == nil || !strings.Contains(err.Error(), "no free server slot") {
+		t.Fatalf("expected the backend to run out of slots, got %v", err)
+	}
+
+	// a backend which isn't running needs a reload
+	model := runtimeModel(t, "192.168.1.4")
+	model.Backends[0].Name = "other.example.com-6443"
+	if err := runtimeAPI.ApplyServers(model); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Fatalf("expected the backend not to be running, got %v", err)
+	}
+}
+
+func TestRuntimeAPIExecute(t *testing.T) {
+	_, runtimeAPI := startFakeRuntimeAPI(t, []RuntimeServer{})
+	if err := runtimeAPI.EnableServer("missing", "slot1"); err == nil || !strings.Contains(err.Error(), "No such server") {
+		t.Fatalf("expected an unknown server to fail, got %v", err)
+	}
+	if _, err := runtimeAPI.Execute("show nothing"); err == nil {
+		t.Fatal("expected an unknown command to fail")
+	}
+	if _, err := (&RuntimeAPI{Address: filepath.Join(t.TempDir(), "missing.sock")}).Execute("show info"); err == nil {
+		t.Fatal("expected a missing socket to fail")
+	}
+}
@@ -0,0 +1,224 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+var inventory *Inventory
+
+const (
+	defaultScanConcurrency     = 25
+	defaultScanProbesPerSecond = 100
+	defaultScanDeadline        = 5 * time.Minute
+	// defaultFullScanInterval is the time between full scans of a range
+	// unless the config sets otherwise
+	defaultFullScanInterval = 15 * time.Minute
+)
+
+// Scanner probes the ports of every address in a set of ranges with a bounded
+// pool of workers. The number of probes in flight is limited across all
+// ranges and each range is probed at a limited rate so large ranges don't
+// flood the network.
+type Scanner struct {
+	// Concurrency is the maximum number of probes in flight
+	Concurrency int
+	// ProbesPerSecond is the maximum rate at which each range is probed
+	ProbesPerSecond int
+	// Deadline is the time a whole scan may take
+	Deadline time.Duration
+	// Inventory, if set, records the outcome of every probe and provides the
+	// targets of the ranges
+	Inventory *Inventory
+	// FullScanInterval is the time between scans of every address of a range.
+	// Between full scans only the addresses in the inventory are probed.
+	FullScanInterval time.Duration
+	// CertificateExpiryWarning is how long before a certificate served by a
+	// cluster expires a warning is raised
+	CertificateExpiryWarning time.Duration
+	// probe checks a single port of an address
+	probe func(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error)
+}
+
+// NewScanner returns a scanner limited by the scan settings of the config.
+func NewScanner(monitorConfig *data.MonitorConfig) *Scanner {
+	scanner := &Scanner{
+		Concurrency:      monitorConfig.Scan.Concurrency,
+		ProbesPerSecond:  monitorConfig.Scan.ProbesPerSecond,
+		Deadline:         time.Duration(monitorConfig.Scan.Deadline) * time.Millisecond,
+		FullScanInterval: time.Duration(monitorConfig.Scan.FullScanInterval) * time.Millisecond,
+		probe:            CheckPort,
+
+		CertificateExpiryWarning: certificateExpiryWarning(monitorConfig.CertificateExpiryWarningDays),
+	}
+	if scanner.Concurrency == 0 {
+		scanner.Concurrency = defaultScanConcurrency
+	}
+	if scanner.ProbesPerSecond == 0 {
+		scanner.ProbesPerSecond = defaultScanProbesPerSecond
+	}
+	if scanner.Deadline == 0 {
+		scanner.Deadline = defaultScanDeadline
+	}
+	if scanner.FullScanInterval == 0 {
+		scanner.FullScanInterval = defaultFullScanInterval
+	}
+	return scanner
+}
+
+type scanProbe struct {
+	monitorRange *data.MonitorRange
+	monitorPort  *data.MonitorPort
+	ip           string
+}
+
+// Scan probes the ranges, replacing the targets of their ports with the
+// addresses which responded. If the scanner has an inventory the targets are
+// the live addresses of the inventory instead. If the deadline passes before
+// every address has been probed the ports hold the targets found so far and
+// an error is returned.
+func (s *Scanner) Scan(ctx context.Context, monitorRanges []*data.MonitorRange) error {
+	ctx, cancel := context.WithTimeout(ctx, s.Deadline)
+	defer cancel()
+	now := time.Now()
+
+	probes := make(chan scanProbe)
+	var workers sync.WaitGroup
+	for i := 0; i < s.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for probe := range probes {
+				status, err := s.probe(ctx, probe.monitorPort, probe.monitorRange, probe.ip)
+				if s.Inventory != nil && ctx.Err() == nil {
+					s.Inventory.Record(RangeKey(probe.monitorRange), probe.ip, probe.monitorPort, status, err, now)
+				}
+			}
+		}()
+	}
+
+	// each range is fed to the workers by its own producer so a slow or rate
+	// limited range doesn't hold up the others
+	var producers sync.WaitGroup
+	fullScans := make([]bool, len(monitorRanges))
+	for idx, monitorRange := range monitorRanges {
+		producers.Add(1)
+		go func(idx int, monitorRange *data.MonitorRange) {
+			defer producers.Done()
+			fullScans[idx] = s.produce(ctx, monitorRange, probes, now)
+		}(idx, monitorRange)
+	}
+	producers.Wait()
+	close(probes)
+	workers.Wait()
+
+	if s.Inventory != nil {
+		for idx, monitorRange := range monitorRanges {
+			if fullScans[idx] && ctx.Err() == nil {
+				s.Inventory.FullScanCompleted(RangeKey(monitorRange), now)
+			}
+			s.Inventory.Apply(monitorRange)
+		}
+		s.Inventory.updateMetrics(time.Now(), s.CertificateExpiryWarning)
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("scan did not complete within %v: %v", s.Deadline, ctx.Err())
+	}
+	return nil
+}
+
+// produce queues the probes of a range, returning true if every address of
+// the range was queued rather than only those in the inventory.
+func (s *Scanner) produce(ctx context.Context, monitorRange *data.MonitorRange, probes chan<- scanProbe, now time.Time) bool {
+	addresses, err := RangeAddresses(monitorRange)
+	if err != nil {
+		logrus.Error(err)
+		return false
+	}
+
+	rangeKey := RangeKey(monitorRange)
+	fullScan := s.Inventory == nil || s.Inventory.FullScanDue(rangeKey, s.FullScanInterval, now)
+	if !fullScan {
+		knownAddresses := s.Inventory.KnownAddresses(rangeKey)
+		known := addresses[:0]
+		for _, ip := range addresses {
+			if knownAddresses[ip.String()] {
+				known = append(known, ip)
+			}
+		}
+		addresses = known
+		logrus.Debugf("probing %d known addresses of %s", len(addresses), rangeKey)
+	}
+
+	mu.Lock()
+	for idx := range monitorRange.MonitorPorts {
+		monitorRange.MonitorPorts[idx].Targets = []string{}
+		monitorRange.MonitorPorts[idx].TargetIssuers = map[string]string{}
+	}
+	monitorRange.TargetDomains = map[string]string{}
+	monitorRange.TargetFirstSeen = map[string]time.Time{}
+	mu.Unlock()
+
+	probesPerSecond := s.ProbesPerSecond
+	if monitorRange.ProbesPerSecond > 0 {
+		probesPerSecond = monitorRange.ProbesPerSecond
+	}
+	limiter := rate.NewLimiter(rate.Limit(probesPerSecond), 1)
+
+	for _, ip := range addresses {
+		for idx := range monitorRange.MonitorPorts {
+			if err := limiter.Wait(ctx); err != nil {
+				return false
+			}
+			select {
+			case probes <- scanProbe{
+				monitorRange: monitorRange,
+				monitorPort:  &monitorRange.MonitorPorts[idx],
+				ip:           ip.String(),
+			}:
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+	return fullScan
+}
+
+// SetInventory sets the inventory used when checking ranges. Ranges are
+// scanned from scratch every time if the inventory is nil.
+func SetInventory(inv *Inventory) {
+	mu.Lock()
+	defer mu.Unlock()
+	inventory = inv
+}
+
+func newScanner() *Scanner {
+	mu.Lock()
+	defer mu.Unlock()
+	scanner := NewScanner(&monitorConfig.MonitorConfig)
+	scanner.Inventory = inventory
+	return scanner
+}
+
+// CheckRanges scans every range of the monitor config.
+func CheckRanges(ctx context.Context) (*data.MonitorConfigSpec, error) {
+	monitorRanges := []*data.MonitorRange{}
+	for idx := range monitorConfig.MonitorConfig.MonitorRanges {
+		monitorRanges = append(monitorRanges, &monitorConfig.MonitorConfig.MonitorRanges[idx])
+	}
+	err := newScanner().Scan(ctx, monitorRanges)
+	return &monitorConfig, err
+}
+
+// CheckRange scans a single range with the scan settings of the monitor
+// config.
+func CheckRange(ctx context.Context, monitorRange *data.MonitorRange) error {
+	return newScanner().Scan(ctx, []*data.MonitorRange{monitorRange})
+}
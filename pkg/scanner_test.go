@@ -0,0 +1,218 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+func TestScannerConcurrency(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	probed := map[string]int{}
+
+	scanner := &Scanner{
+		Concurrency:     4,
+		ProbesPerSecond: 1000,
+		Deadline:        10 * time.Second,
+		probe: func(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			probed[ip]++
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+			return &PortStatus{}, nil
+		},
+	}
+
+	ports := []data.MonitorPort{{Port: 6443}, {Port: 443}}
+	monitorRanges := []*data.MonitorRange{
+		{IpCidr: "192.168.1.0/28", MonitorPorts: append([]data.MonitorPort{}, ports...)},
+		{IpCidr: "192.168.2.0/28", MonitorPorts: append([]data.MonitorPort{}, ports...)},
+		{IpCidr: "192.168.3.0/28", MonitorPorts: append([]data.MonitorPort{}, ports...)},
+	}
+	if err := scanner.Scan(context.Background(), monitorRanges); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxInFlight > scanner.Concurrency {
+		t.Fatalf("expected at most %d probes in flight, got %d", scanner.Concurrency, maxInFlight)
+	}
+	if len(probed) != 3*14 {
+		t.Fatalf("expected %d addresses to be probed, got %d", 3*14, len(probed))
+	}
+	for ip, count := range probed {
+		if count != len(ports) {
+			t.Fatalf("expected %s to be probed %d times, got %d", ip, len(ports), count)
+		}
+	}
+}
+
+func TestScannerRateLimit(t *testing.T) {
+	var lock sync.Mutex
+	probed := 0
+
+	scanner := &Scanner{
+		Concurrency:     10,
+		ProbesPerSecond: 1000,
+		Deadline:        10 * time.Second,
+		probe: func(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error) {
+			lock.Lock()
+			probed++
+			lock.Unlock()
+			return &PortStatus{}, nil
+		},
+	}
+
+	monitorRanges := []*data.MonitorRange{
+		{IpCidr: "192.168.1.0/29", ProbesPerSecond: 20, MonitorPorts: []data.MonitorPort{{Port: 6443}}},
+	}
+	start := time.Now()
+	if err := scanner.Scan(context.Background(), monitorRanges); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first probe is allowed immediately, the other 5 at 20 per second
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("expected the scan to be rate limited, took %v", elapsed)
+	}
+	if probed != 6 {
+		t.Fatalf("expected 6 probes, got %d", probed)
+	}
+}
+
+func TestScannerDeadline(t *testing.T) {
+	scanner := &Scanner{
+		Concurrency:     2,
+		ProbesPerSecond: 1000,
+		Deadline:        50 * time.Millisecond,
+		probe: func(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error) {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			return nil, ctx.Err()
+		},
+	}
+
+	monitorRanges := []*data.MonitorRange{
+		{IpCidr: "192.168.1.0/24", MonitorPorts: []data.MonitorPort{{Port: 6443}}},
+	}
+	start := time.Now()
+	if err := scanner.Scan(context.Background(), monitorRanges); err == nil {
+		t.Fatal("expected the scan to exceed its deadline")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the scan to stop at its deadline, took %v", elapsed)
+	}
+}
+
+func TestScannerInventory(t *testing.T) {
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	live := map[string]bool{"192.168.1.2": true, "192.168.1.5": true}
+	probed := map[string]int{}
+
+	scanner := &Scanner{
+		Concurrency:      4,
+		ProbesPerSecond:  1000,
+		Deadline:         10 * time.Second,
+		Inventory:        inventory,
+		FullScanInterval: time.Hour,
+		probe: func(ctx context.Context, monitorPort *data.MonitorPort, monitorRange *data.MonitorRange, ip string) (*PortStatus, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			probed[ip]++
+			if !live[ip] {
+				return nil, fmt.Errorf("connection refused")
+			}
+			return &PortStatus{BaseDomain: "cluster.example.com", Fingerprint: "abc"}, nil
+		},
+	}
+
+	monitorRange := &data.MonitorRange{IpCidr: "192.168.1.0/29", MonitorPorts: []data.MonitorPort{{Port: 6443}}}
+	if err := scanner.Scan(context.Background(), []*data.MonitorRange{monitorRange}); err != nil {
+		t.Fatal(err)
+	}
+	if len(probed) != 6 {
+		t.Fatalf("expected a full scan of 6 addresses, got %v", probed)
+	}
+	if !reflect.DeepEqual(monitorRange.MonitorPorts[0].Targets, []string{"192.168.1.2", "192.168.1.5"}) {
+		t.Fatalf("unexpected targets %v", monitorRange.MonitorPorts[0].Targets)
+	}
+
+	// only the known addresses are probed until a full scan is due, and an
+	// address which stops responding is no longer a target
+	live["192.168.1.5"] = false
+	live["192.168.1.3"] = true
+	probed = map[string]int{}
+	if err := scanner.Scan(context.Background(), []*data.MonitorRange{monitorRange}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(probed, map[string]int{"192.168.1.2": 1, "192.168.1.5": 1}) {
+		t.Fatalf("expected only known addresses to be probed, got %v", probed)
+	}
+	if !reflect.DeepEqual(monitorRange.MonitorPorts[0].Targets, []string{"192.168.1.2"}) {
+		t.Fatalf("unexpected targets %v", monitorRange.MonitorPorts[0].Targets)
+	}
+	if monitorRange.TargetDomains["192.168.1.2"] != "cluster.example.com" {
+		t.Fatalf("unexpected target domains %v", monitorRange.TargetDomains)
+	}
+
+	scanner.FullScanInterval = 0
+	if err := scanner.Scan(context.Background(), []*data.MonitorRange{monitorRange}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(monitorRange.MonitorPorts[0].Targets, []string{"192.168.1.2", "192.168.1.3"}) {
+		t.Fatalf("unexpected targets %v", monitorRange.MonitorPorts[0].Targets)
+	}
+}
+
+func TestNewScannerDefaults(t *testing.T) {
+	scanner := NewScanner(&data.MonitorConfig{})
+	if scanner.Concurrency != defaultScanConcurrency || scanner.ProbesPerSecond != defaultScanProbesPerSecond ||
+		scanner.Deadline != defaultScanDeadline || scanner.FullScanInterval != defaultFullScanInterval {
+		t.Fatalf("expected the scan defaults, got %+v", scanner)
+	}
+
+	scanner = NewScanner(&data.MonitorConfig{Scan: data.ScanConfig{FullScanInterval: 60000}})
+	if scanner.FullScanInterval != time.Minute {
+		t.Fatalf("expected a full scan interval of a minute, got %v", scanner.FullScanInterval)
+	}
+}
+
+func TestNewScannerInventory(t *testing.T) {
+	defer SetInventory(nil)
+
+	// without an inventory ranges are scanned from scratch
+	SetInventory(nil)
+	if scanner := newScanner(); scanner.Inventory != nil {
+		t.Fatalf("expected no inventory, got %v", scanner.Inventory)
+	}
+
+	inventory, err := NewInventory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetInventory(inventory)
+	if scanner := newScanner(); scanner.Inventory != inventory {
+		t.Fatal("expected the inventory which was set")
+	}
+}
@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+	haproxy "github.com/openshift-splat-team/haproxy-dyna-configure/data/haproxy"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+// Standalone scans the monitor ranges on an interval and renders the HAProxy
+// configuration, or that of the proxy chosen by the output renderer, to a
+// file without Kubernetes. The proxy is reloaded each time the rendered
+// configuration changes.
+type Standalone struct {
+	// ConfigPath is the path of the monitor config, which is reloaded when it
+	// changes
+	ConfigPath string
+	// OutputPath is the path the configuration is written to
+	OutputPath string
+	// Interval is the time between scans
+	Interval time.Duration
+	// Reloader reloads the proxy after the configuration is written. The
+	// proxy is not reloaded if it is nil.
+	Reloader Reloader
+	// Inventory, if set, remembers the addresses found by previous scans and
+	// is saved after every scan. Without it the ranges are scanned with an
+	// inventory which is only kept in memory, so target changes are still
+	// damped and known addresses still probed between full scans.
+	Inventory *Inventory
+
+	configLock    sync.Mutex
+	pendingConfig *data.MonitorConfig
+	lastHash      string
+	rendered      bool
+}
+
+// Run scans and renders until ctx is done.
+func (s *Standalone) Run(ctx context.Context) error {
+	config, err := LoadConfig(s.ConfigPath)
+	if err != nil {
+		return err
+	}
+	SetConfig(config)
+	// target changes are damped by the inventory the ranges are scanned
+	// with, which is only kept in memory if none is set. That one is
+	// neither saved nor rendered from before the first scan.
+	scanInventory := s.Inventory
+	if scanInventory == nil {
+		if scanInventory, err = NewInventory(nil); err != nil {
+			return err
+		}
+	}
+	SetInventory(scanInventory)
+
+	if err := WatchConfigFile(ctx, s.ConfigPath, s.setPendingConfig); err != nil {
+		return err
+	}
+
+	// serve the clusters in the inventory while the first scan runs
+	if s.Inventory != nil {
+		s.Inventory.Prune(config.MonitorRanges)
+		if err := s.render(applyInventory(config, s.Inventory)); err != nil {
+			logrus.Errorf("unable to render HAProxy configuration from the inventory: %v", err)
+		}
+	}
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sync(ctx); err != nil {
+			logrus.Errorf("unable to sync HAProxy configuration: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (s *Standalone) setPendingConfig(config *data.MonitorConfig) {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	s.pendingConfig = config
+}
+
+// applyPendingConfig switches to a config reloaded since the last scan. The
+// config is only replaced between scans as the scan updates it in place.
+func (s *Standalone) applyPendingConfig() {
+	s.configLock.Lock()
+	defer s.configLock.Unlock()
+	if s.pendingConfig != nil {
+		SetConfig(s.pendingConfig)
+		if s.Inventory != nil {
+			s.Inventory.Prune(s.pendingConfig.MonitorRanges)
+		}
+		s.pendingConfig = nil
+	}
+}
+
+// Sync scans the monitor ranges and, if the rendered configuration has
+// changed, writes it and reloads HAProxy. Without an inventory nothing is
+// written if the scan does not complete, as clusters which were not probed
+// would be dropped.
+func (s *Standalone) Sync(ctx context.Context) error {
+	s.applyPendingConfig()
+
+	spec, scanErr := CheckRanges(ctx)
+	if s.Inventory != nil {
+		if err := s.Inventory.Save(); err != nil {
+			logrus.Errorf("%v", err)
+		}
+	}
+	if scanErr != nil {
+		if s.Inventory == nil {
+			return fmt.Errorf("unable to scan ranges: %v", scanErr)
+		}
+		logrus.Warnf("scan incomplete, rendering from the inventory: %v", scanErr)
+	}
+	return s.render(&spec.MonitorConfig)
+}
+
+// render writes the configuration for the monitor config and reloads the
+// proxy if it has changed. If the config has a Runtime API, changes to the
+// targets of HAProxy backends are applied through it and written without a
+// reload.
+func (s *Standalone) render(config *data.MonitorConfig) error {
+	_, conflicts := DiscoveredClusters(config.MonitorRanges, config.ConflictResolution)
+	ReportConflicts(conflicts)
+
+	// map files are written next to the HAProxy configuration unless the
+	// config says where HAProxy reads them from
+	if config.SNIRouting.Mode == SNIRoutingMap && len(config.SNIRouting.MapDirectory) == 0 {
+		withMapDirectory := *config
+		withMapDirectory.SNIRouting.MapDirectory = filepath.Dir(s.OutputPath)
+		config = &withMapDirectory
+	}
+
+	renderer, err := NewRenderer(config.Output)
+	if err != nil {
+		return err
+	}
+	var files map[string]string
+	var hash string
+	var model *haproxy.Config
+	if _, isHAProxy := renderer.(HAProxyRenderer); isHAProxy {
+		files, hash, model, err = BuildTargetHAProxy(config)
+	} else {
+		files, hash, err = renderer.Render(config)
+	}
+	if err != nil {
+		return err
+	}
+	configFile := renderer.ConfigFile()
+
+	// an unchanged configuration from a previous run doesn't need a reload
+	if !s.rendered {
+		s.rendered = true
+		if s.written(files, configFile, config.SNIRouting.MapDirectory) {
+			logrus.Infof("configuration is unchanged since the last run")
+			s.lastHash = hash
+		}
+	}
+	if hash == s.lastHash {
+		// the Runtime API is only used with HAProxy
+		runtimeAPI := NewRuntimeAPI(config.RuntimeAPI)
+		if runtimeAPI == nil || model == nil || s.written(files, configFile, config.SNIRouting.MapDirectory) {
+			logrus.Debugf("configuration is unchanged")
+			return nil
+		}
+		// the configuration is still written so a later reload serves the
+		// same targets
+		err := runtimeAPI.ApplyServers(model)
+		if err == nil {
+			logrus.Infof("updated HAProxy servers through the runtime API")
+			return s.writeFiles(files, configFile, config.SNIRouting.MapDirectory)
+		}
+		logrus.Warnf("unable to update HAProxy servers through the runtime API, reloading: %v", err)
+	}
+
+	if err := s.writeFiles(files, configFile, config.SNIRouting.MapDirectory); err != nil {
+		return err
+	}
+	if s.Reloader != nil {
+		if err := s.Reloader.Reload(); err != nil {
+			return fmt.Errorf("unable to reload the proxy: %v", err)
+		}
+	}
+	s.lastHash = hash
+	return nil
+}
+
+// writeFiles writes the map files and then the configuration, which is the
+// file named configFile.
+func (s *Standalone) writeFiles(files map[string]string, configFile, mapDirectory string) error {
+	// the maps are written first as the configuration references them
+	names := []string{}
+	for name := range files {
+		if name != configFile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mapPath := filepath.Join(mapDirectory, name)
+		logrus.Infof("writing HAProxy map to %s", mapPath)
+		if err := util.WriteFileAtomic(mapPath, []byte(files[name]), 0644); err != nil {
+			return err
+		}
+	}
+
+	logrus.Infof("writing %s to %s", configFile, s.OutputPath)
+	return util.WriteFileAtomic(s.OutputPath, []byte(files[configFile]), 0644)
+}
+
+// written reports whether the files have already been written with the same
+// content.
+func (s *Standalone) written(files map[string]string, configFile, mapDirectory string) bool {
+	for name, content := range files {
+		filePath := s.OutputPath
+		if name != configFile {
+			filePath = filepath.Join(mapDirectory, name)
+		}
+		if existing, err := os.ReadFile(filePath); err != nil || string(existing) != content {
+			return false
+		}
+	}
+	return true
+}
+
+// applyInventory returns a copy of the config whose ranges hold the targets
+// in the inventory.
+func applyInventory(config *data.MonitorConfig, inventory *Inventory) *data.MonitorConfig {
+	applied := *config
+	applied.MonitorRanges = []data.MonitorRange{}
+	for _, monitorRange := range config.MonitorRanges {
+		monitorRange.MonitorPorts = append([]data.MonitorPort{}, monitorRange.MonitorPorts...)
+		inventory.Apply(&monitorRange)
+		applied.MonitorRanges = append(applied.MonitorRanges, monitorRange)
+	}
+	return &applied
+}
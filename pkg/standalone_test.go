@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+type fakeReloader struct {
+	reloads int
+	err     error
+}
+
+func (r *fakeReloader) Reload() error {
+	r.reloads++
+	return r.err
+}
+
+func TestStandaloneSync(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "haproxy.cfg")
+	reloader := &fakeReloader{}
+	daemon := &Standalone{
+		OutputPath: outputPath,
+		Reloader:   reloader,
+	}
+
+	daemon.setPendingConfig(&data.MonitorConfig{HaproxyHeader: "global\n  maxconn 100\n"})
+	if err := daemon.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "global\n  maxconn 100\n" {
+		t.Fatalf("unexpected HAProxy configuration: %q", content)
+	}
+	if reloader.reloads != 1 {
+		t.Fatalf("expected HAProxy to be reloaded once, got %d", reloader.reloads)
+	}
+
+	// an unchanged configuration is neither written nor reloaded
+	if err := daemon.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.reloads != 1 {
+		t.Fatalf("expected HAProxy not to be reloaded, got %d reloads", reloader.reloads)
+	}
+
+	// a failed reload is retried on the next sync
+	daemon.setPendingConfig(&data.MonitorConfig{HaproxyHeader: "global\n  maxconn 200\n"})
+	reloader.err = fmt.Errorf("reload failed")
+	if err := daemon.Sync(context.Background()); err == nil {
+		t.Fatal("expected the failed reload to be reported")
+	}
+	reloader.err = nil
+	if err := daemon.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.reloads != 3 {
+		t.Fatalf("expected the reload to be retried, got %d reloads", reloader.reloads)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the HAProxy configuration to be left, got %v", entries)
+	}
+
+	// a configuration written by a previous run is not reloaded
+	restarted := &Standalone{
+		OutputPath: outputPath,
+		Reloader:   reloader,
+	}
+	restarted.setPendingConfig(&data.MonitorConfig{HaproxyHeader: "global\n  maxconn 200\n"})
+	if err := restarted.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.reloads != 3 {
+		t.Fatalf("expected HAProxy not to be reloaded after a restart, got %d reloads", reloader.reloads)
+	}
+}
+
+func TestStandaloneRenderMaps(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "haproxy.cfg")
+	daemon := &Standalone{OutputPath: outputPath}
+
+	config := &data.MonitorConfig{
+		SNIRouting: data.SNIRoutingConfig{Mode: SNIRoutingMap},
+		MonitorRanges: []data.MonitorRange{{
+			BaseDomain: "cluster.example.com",
+			MonitorPorts: []data.MonitorPort{
+				{Port: 443, PathPrefix: "*.apps", Targets: []string{"192.168.1.5"}},
+			},
+		}},
+	}
+	if err := daemon.render(config); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapPath := filepath.Join(dir, "dynaconfig-fe-443.map")
+	if !strings.Contains(string(content), "map_end("+mapPath+")") {
+		t.Fatalf("expected the configuration to reference %s:\n%s", mapPath, content)
+	}
+	mapContent, err := os.ReadFile(mapPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mapContent) != ".apps.cluster.example.com cluster.example.com-443\n" {
+		t.Fatalf("unexpected map %q", mapContent)
+	}
+}
+
+func TestStandaloneRenderNginxStream(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "nginx.conf")
+	reloader := &fakeReloader{}
+	daemon := &Standalone{OutputPath: outputPath, Reloader: reloader}
+
+	config := &data.MonitorConfig{
+		Output: data.OutputConfig{Renderer: RendererNginxStream},
+		MonitorRanges: []data.MonitorRange{{
+			BaseDomain:   "cluster.example.com",
+			MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api", Targets: []string{"192.168.1.4"}}},
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		if err := daemon.render(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reloader.reloads != 1 {
+		t.Fatalf("expected a single reload, got %d", reloader.reloads)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "proxy_pass $dynaconfig_fe_6443;") {
+		t.Fatalf("expected the nginx configuration to be written:\n%s", content)
+	}
+}
+
+func TestStandaloneRenderRuntimeAPI(t *testing.T) {
+	const backend = "cluster.example.com-6443"
+	fake, runtimeAPI := startFakeRuntimeAPI(t, []RuntimeServer{
+		{Backend: backend, Name: "slot1", Address: "192.168.1.4", Port: 6443},
+		{Backend: backend, Name: "slot2", Address: "0.0.0.0", Port: 6443, Disabled: true},
+	})
+	outputPath := filepath.Join(t.TempDir(), "haproxy.cfg")
+	reloader := &fakeReloader{}
+	daemon := &Standalone{OutputPath: outputPath, Reloader: reloader}
+
+	config := func(target string) *data.MonitorConfig {
+		return &data.MonitorConfig{
+			RuntimeAPI: data.RuntimeAPIConfig{Address: runtimeAPI.Address, ServerSlots: 2},
+			MonitorRanges: []data.MonitorRange{{
+				BaseDomain:   "cluster.example.com",
+				MonitorPorts: []data.MonitorPort{{Port: 6443, PathMatch: "api", Targets: []string{target}}},
+			}},
+		}
+	}
+	if err := daemon.render(config("192.168.1.4")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a changed target is applied through the runtime API and written
+	// without a reload
+	if err := daemon.render(config("192.168.1.5")); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.reloads != 1 {
+		t.Fatalf("expected HAProxy to be reloaded once, got %d", reloader.reloads)
+	}
+	servers, _ := fake.state()
+	if !servers[0].Disabled || servers[1].Address != "192.168.1.5" || servers[1].Disabled {
+		t.Fatalf("expected the target to be moved through the runtime API, got %v", servers)
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "server slot1 192.168.1.5:6443") {
+		t.Fatalf("expected the new target to be written:\n%s", content)
+	}
+
+	// HAProxy is reloaded if the runtime API can't be used
+	unreachable := config("192.168.1.6")
+	unreachable.RuntimeAPI.Address = filepath.Join(t.TempDir(), "missing.sock")
+	if err := daemon.render(unreachable); err != nil {
+		t.Fatal(err)
+	}
+	if reloader.reloads != 2 {
+		t.Fatalf("expected HAProxy to be reloaded, got %d reloads", reloader.reloads)
+	}
+}
+
+func TestMasterSocketReloader(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "master.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	responses := []string{"Success=1\n--\n", "Success=0\n--\n[NOTICE] invalid config\n", ""}
+	commands := make(chan string, len(responses))
+	go func() {
+		for _, response := range responses {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			commands <- command
+			conn.Write([]byte(response))
+			conn.Close()
+		}
+	}()
+
+	reloader := &MasterSocketReloader{Path: socketPath}
+	for _, expectErr := range []bool{false, true, false} {
+		err := reloader.Reload()
+		if expectErr != (err != nil) {
+			t.Fatalf("expected error: %v, got %v", expectErr, err)
+		}
+		if command := <-commands; command != "reload\n" {
+			t.Fatalf("expected the reload command, got %q", command)
+		}
+	}
+}
+
+func TestPidfileReloader(t *testing.T) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	pidfile := filepath.Join(t.TempDir(), "haproxy.pid")
+	if err := os.WriteFile(pidfile, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PidfileReloader{Path: pidfile}).Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-signals:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGUSR2 to be sent")
+	}
+
+	// other proxies are reloaded with their own signal
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+	if err := (&PidfileReloader{Path: pidfile, Signal: syscall.SIGHUP}).Reload(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-hangups:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGHUP to be sent")
+	}
+
+	if err := os.WriteFile(pidfile, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PidfileReloader{Path: pidfile}).Reload(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Fatalf("expected an empty pidfile to be reported, got %v", err)
+	}
+}
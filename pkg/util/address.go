@@ -0,0 +1,14 @@
+package util
+
+import "net/netip"
+
+// AddressLess orders IP addresses numerically. Strings which are not
+// addresses are ordered as strings.
+func AddressLess(a, b string) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA == nil && errB == nil {
+		return addrA.Less(addrB)
+	}
+	return a < b
+}
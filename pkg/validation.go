@@ -0,0 +1,439 @@
+package pkg
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"github.com/netdata/go.d.plugin/pkg/iprange"
+	"github.com/openshift-splat-team/haproxy-dyna-configure/data"
+)
+
+// ValidationError is a single problem found in a monitor config. Path is the
+// YAML path of the offending value.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors is every problem found while validating a monitor config.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for idx, validationError := range e {
+		messages[idx] = validationError.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e *ValidationErrors) add(path, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// dedupe removes repeated errors, such as those found in a port profile which is
+// shared by several ranges.
+func (e ValidationErrors) dedupe() ValidationErrors {
+	seen := map[ValidationError]bool{}
+	var errs ValidationErrors
+	for _, validationError := range e {
+		if !seen[validationError] {
+			seen[validationError] = true
+			errs = append(errs, validationError)
+		}
+	}
+	return errs
+}
+
+// ValidateConfig parses the raw monitor config and validates it. Unknown keys
+// are reported along with every problem found by ValidateConfigSpec.
+func ValidateConfig(configRaw []byte) ValidationErrors {
+	var errs ValidationErrors
+
+	var untyped interface{}
+	if err := yaml.Unmarshal(configRaw, &untyped); err != nil {
+		errs.add("", "unable to parse: %v", err)
+		return errs
+	}
+	errs = append(errs, validateKeys("", untyped, reflect.TypeOf(data.MonitorConfigSpec{}))...)
+
+	var monitorConfigSpec data.MonitorConfigSpec
+	if err := yaml.Unmarshal(configRaw, &monitorConfigSpec); err != nil {
+		errs.add("", "unable to unmarshall monitor config: %v", err)
+		return errs
+	}
+	return append(errs, ValidateConfigSpec(&monitorConfigSpec)...)
+}
+
+// ValidateConfigSpec checks the monitor config for problems which would
+// otherwise only be noticed while scanning.
+func ValidateConfigSpec(spec *data.MonitorConfigSpec) ValidationErrors {
+	var errs ValidationErrors
+	path := "monitor-config"
+	monitorConfig := &spec.MonitorConfig
+
+	if monitorConfig.CheckTimeout < 0 {
+		errs.add(path+".check-timeout", "must not be negative")
+	}
+	if monitorConfig.Scan.Concurrency < 0 {
+		errs.add(path+".scan.concurrency", "must not be negative")
+	}
+	if monitorConfig.Scan.ProbesPerSecond < 0 {
+		errs.add(path+".scan.probes-per-second", "must not be negative")
+	}
+	if monitorConfig.Scan.Deadline < 0 {
+		errs.add(path+".scan.deadline", "must not be negative")
+	}
+	if monitorConfig.Scan.FullScanInterval < 0 {
+		errs.add(path+".scan.full-scan-interval", "must not be negative")
+	}
+	if monitorConfig.CertificateExpiryWarningDays < 0 {
+		errs.add(path+".certificate-expiry-warning-days", "must not be negative")
+	}
+
+	switch monitorConfig.SNIRouting.Mode {
+	case "", SNIRoutingRules, SNIRoutingMap:
+	default:
+		errs.add(path+".sni-routing.mode", "unknown SNI routing mode %q", monitorConfig.SNIRouting.Mode)
+	}
+	if len(monitorConfig.SNIRouting.MapDirectory) > 0 && !strings.HasPrefix(monitorConfig.SNIRouting.MapDirectory, "/") {
+		errs.add(path+".sni-routing.map-directory", "must be an absolute path")
+	}
+	if monitorConfig.RuntimeAPI.ServerSlots < 0 {
+		errs.add(path+".runtime-api.server-slots", "must not be negative")
+	}
+	switch monitorConfig.Output.Type {
+	case "", OutputConfigMap:
+	case OutputDataPlaneAPI:
+		dataPlaneAPI := monitorConfig.Output.DataPlaneAPI
+		if apiURL, err := url.Parse(dataPlaneAPI.URL); err != nil || (apiURL.Scheme != "http" && apiURL.Scheme != "https") || len(apiURL.Host) == 0 {
+			errs.add(path+".output.data-plane-api.url", "must be an http or https URL")
+		}
+		if dataPlaneAPI.Retries < 0 {
+			errs.add(path+".output.data-plane-api.retries", "must not be negative")
+		}
+		// map files can't be shipped through the Data Plane API
+		if monitorConfig.SNIRouting.Mode == SNIRoutingMap {
+			errs.add(path+".output.type", "can't be used with the map SNI routing mode")
+		}
+	default:
+		errs.add(path+".output.type", "unknown output %q", monitorConfig.Output.Type)
+	}
+	switch monitorConfig.Output.Renderer {
+	case "", RendererHAProxy:
+	case RendererNginxStream, RendererEnvoy, RendererTraefik:
+		// the other proxies are only given the files they read
+		if monitorConfig.Output.Type == OutputDataPlaneAPI {
+			errs.add(path+".output.renderer", "can't be used with the Data Plane API output")
+		}
+		if monitorConfig.SNIRouting.Mode == SNIRoutingMap {
+			errs.add(path+".output.renderer", "can't be used with the map SNI routing mode")
+		}
+		if len(monitorConfig.RuntimeAPI.Address) > 0 {
+			errs.add(path+".output.renderer", "can't be used with the runtime API")
+		}
+	default:
+		errs.add(path+".output.renderer", "unknown renderer %q", monitorConfig.Output.Renderer)
+	}
+	switch monitorConfig.ConflictResolution {
+	case "", ConflictPinFirst, ConflictNewestWins, ConflictRefuse:
+	default:
+		errs.add(path+".conflict-resolution", "unknown conflict resolution %q", monitorConfig.ConflictResolution)
+	}
+	for idx, suffix := range monitorConfig.DomainPolicy.AllowedSuffixes {
+		if len(normalizeDomain(suffix)) == 0 {
+			errs.add(fmt.Sprintf("%s.domain-policy.allowed-suffixes[%d]", path, idx), "must not be empty")
+		}
+	}
+	for idx, denied := range monitorConfig.DomainPolicy.DeniedDomains {
+		if len(normalizeDomain(denied)) == 0 {
+			errs.add(fmt.Sprintf("%s.domain-policy.denied-domains[%d]", path, idx), "must not be empty")
+		}
+	}
+
+	if _, err := CompileNamespaceRules(monitorConfig.NamespaceRules); err != nil {
+		errs = append(errs, err.(ValidationErrors)...)
+	}
+
+	profiles := portProfiles(monitorConfig)
+	profileNames := []string{}
+	for name := range monitorConfig.PortProfiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+	for _, name := range profileNames {
+		ports, paths, _ := resolvePorts(profiles, name, nil, nil, "", "", "")
+		validateMonitorPorts(&errs, portProfilePath(name), ports, paths)
+	}
+
+	subnetRanges := []data.MonitorRange{}
+	if len(monitorConfig.SubnetsJson) > 0 {
+		var err error
+		if subnetRanges, err = parseSubnetsJson(monitorConfig.SubnetsJson, "", nil); err != nil {
+			errs.add(path+".subnets-json-path", "%v", err)
+		}
+		ports, paths, err := resolvePorts(profiles, subnetsPortProfile(monitorConfig), monitorConfig.SubnetsPortOverrides, nil,
+			path+".subnets-port-profile", path+".subnets-port-overrides", "")
+		if err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		} else {
+			validateMonitorPorts(&errs, path+".subnets-port-profile", ports, paths)
+		}
+	}
+
+	type parsedRange struct {
+		path      string
+		addrRange iprange.Range
+		start     netip.Addr
+	}
+	parsedRanges := []parsedRange{}
+	addParsedRange := func(rangePath string, monitorRange *data.MonitorRange) {
+		if !validateRangeAddresses(&errs, rangePath, monitorRange) {
+			return
+		}
+		addrRange, start, err := parseRange(monitorRange)
+		if err != nil {
+			errs.add(rangePath, "%v", err)
+			return
+		}
+		for _, other := range parsedRanges {
+			if rangeContains(other.addrRange, start) || rangeContains(addrRange, other.start) {
+				errs.add(rangePath, "overlaps %s", other.path)
+			}
+		}
+		parsedRanges = append(parsedRanges, parsedRange{
+			path:      rangePath,
+			addrRange: addrRange,
+			start:     start,
+		})
+	}
+
+	for idx, monitorRange := range monitorConfig.MonitorRanges {
+		rangePath := fmt.Sprintf("%s.monitor-ranges[%d]", path, idx)
+		addParsedRange(rangePath, &monitorRange)
+		if monitorRange.ProbesPerSecond < 0 {
+			errs.add(rangePath+".probes-per-second", "must not be negative")
+		}
+		for patternIdx, pattern := range monitorRange.ExpectedDomains {
+			if _, err := compileExpectedDomain(pattern); err != nil {
+				errs.add(fmt.Sprintf("%s.expected-domains[%d]", rangePath, patternIdx), "%v", err)
+			}
+		}
+		for excludeIdx, exclude := range monitorRange.Exclude {
+			if _, err := parseExcludes([]string{exclude}); err != nil {
+				errs.add(fmt.Sprintf("%s.exclude[%d]", rangePath, excludeIdx), "%v", err)
+			}
+		}
+		ports, paths, err := resolvePorts(profiles, monitorRange.PortProfile, monitorRange.PortOverrides, monitorRange.MonitorPorts,
+			rangePath+".port-profile", rangePath+".port-overrides", rangePath+".monitor-ports")
+		if err != nil {
+			errs = append(errs, err.(ValidationErrors)...)
+		} else {
+			validateMonitorPorts(&errs, rangePath+".monitor-ports", ports, paths)
+		}
+	}
+
+	// the vlans of the subnets json are in no particular order, so they are
+	// ordered by their first address to report the same overlaps every time
+	sort.SliceStable(subnetRanges, func(a, b int) bool {
+		return subnetRanges[a].IpAddressStart < subnetRanges[b].IpAddressStart
+	})
+	for _, monitorRange := range subnetRanges {
+		rangePath := fmt.Sprintf("%s.subnets-json-path[%s-%s]", path, monitorRange.IpAddressStart, monitorRange.IpAddressEnd)
+		addParsedRange(rangePath, &monitorRange)
+	}
+	return errs.dedupe()
+}
+
+// validateRangeAddresses checks the fields which define the addresses of a
+// range, returning true if they can be parsed.
+func validateRangeAddresses(errs *ValidationErrors, path string, monitorRange *data.MonitorRange) bool {
+	if len(monitorRange.IpCidr) > 0 {
+		if len(monitorRange.IpAddressStart) > 0 || len(monitorRange.IpAddressEnd) > 0 {
+			errs.add(path, "ip-cidr can not be combined with ip-address-start or ip-address-end")
+			return false
+		}
+		if _, err := netip.ParsePrefix(monitorRange.IpCidr); err != nil {
+			errs.add(path+".ip-cidr", "invalid CIDR %q", monitorRange.IpCidr)
+			return false
+		}
+		return true
+	}
+
+	var start, end netip.Addr
+	var err error
+	ok := true
+
+	if len(monitorRange.IpAddressStart) == 0 {
+		errs.add(path+".ip-address-start", "is required when ip-cidr is not set")
+		ok = false
+	} else if start, err = netip.ParseAddr(monitorRange.IpAddressStart); err != nil {
+		errs.add(path+".ip-address-start", "invalid address %q", monitorRange.IpAddressStart)
+		ok = false
+	}
+
+	if len(monitorRange.IpAddressEnd) == 0 {
+		errs.add(path+".ip-address-end", "is required when ip-cidr is not set")
+		ok = false
+	} else if end, err = netip.ParseAddr(monitorRange.IpAddressEnd); err != nil {
+		errs.add(path+".ip-address-end", "invalid address %q", monitorRange.IpAddressEnd)
+		ok = false
+	}
+
+	if !ok {
+		return false
+	}
+	if start.BitLen() != end.BitLen() {
+		errs.add(path, "ip-address-start and ip-address-end must be the same address family")
+		return false
+	}
+	if start.Compare(end) > 0 {
+		errs.add(path, "ip-address-start %s is after ip-address-end %s", start, end)
+		return false
+	}
+	return true
+}
+
+// validateMonitorPorts checks the effective ports of a range or profile. paths
+// holds the YAML path which defined each port.
+func validateMonitorPorts(errs *ValidationErrors, path string, monitorPorts []data.MonitorPort, paths []string) {
+	if len(monitorPorts) == 0 {
+		errs.add(path, "at least one port is required")
+		return
+	}
+
+	ports := map[int64]int{}
+	for idx, monitorPort := range monitorPorts {
+		portPath := paths[idx]
+		if monitorPort.Port < 1 || monitorPort.Port > 65535 {
+			errs.add(portPath+".port", "%d is not a valid port", monitorPort.Port)
+		} else if prevIdx, exists := ports[monitorPort.Port]; exists {
+			errs.add(portPath+".port", "duplicates port %d of %s", monitorPort.Port, paths[prevIdx])
+		} else {
+			ports[monitorPort.Port] = idx
+		}
+
+		if len(monitorPort.PathMatch) == 0 && len(monitorPort.PathPrefix) == 0 {
+			errs.add(portPath, "one of path-match or path-prefix is required")
+		}
+
+		for ruleIdx, rule := range monitorPort.DomainRules {
+			if _, err := compileDomainRule(rule); err != nil {
+				errs.add(fmt.Sprintf("%s.domain-rules[%d]", portPath, ruleIdx), "invalid rule: %v", err)
+			}
+		}
+
+		if monitorPort.Rise < 0 {
+			errs.add(portPath+".rise", "must not be negative")
+		}
+		if monitorPort.Fall < 0 {
+			errs.add(portPath+".fall", "must not be negative")
+		}
+		if monitorPort.HoldTime < 0 {
+			errs.add(portPath+".hold-time", "must not be negative")
+		}
+
+		switch monitorPort.Protocol {
+		case "", ProtocolTLS, ProtocolHTTPS:
+		case ProtocolTCP, ProtocolHTTP:
+			if len(monitorPort.SNI) > 0 {
+				errs.add(portPath+".sni", "is only sent by tls and https probes")
+			}
+		default:
+			errs.add(portPath+".protocol", "unsupported protocol %q", monitorPort.Protocol)
+		}
+	}
+}
+
+// yamlFieldName returns the key a struct field is decoded from.
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if len(name) == 0 {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+// stringKeys returns a YAML mapping keyed by strings along with its sorted keys.
+func stringKeys(node interface{}) (map[string]interface{}, []string, bool) {
+	untyped, ok := node.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, false
+	}
+	values := map[string]interface{}{}
+	keys := []string{}
+	for key, value := range untyped {
+		values[fmt.Sprint(key)] = value
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+	return values, keys, true
+}
+
+// validateKeys walks the untyped YAML document alongside the type it will be
+// decoded into and reports keys which don't map to a field.
+func validateKeys(path string, node interface{}, t reflect.Type) ValidationErrors {
+	var errs ValidationErrors
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		values, keys, ok := stringKeys(node)
+		if !ok {
+			return nil
+		}
+		fields := map[string]reflect.Type{}
+		for idx := 0; idx < t.NumField(); idx++ {
+			field := t.Field(idx)
+			if name := yamlFieldName(field); name != "-" {
+				fields[name] = field.Type
+			}
+		}
+
+		for _, key := range keys {
+			fieldType, exists := fields[key]
+			if !exists {
+				errs.add(joinPath(path, key), "unknown field")
+				continue
+			}
+			errs = append(errs, validateKeys(joinPath(path, key), values[key], fieldType)...)
+		}
+	case reflect.Slice:
+		items, ok := node.([]interface{})
+		if !ok {
+			return nil
+		}
+		for idx, item := range items {
+			errs = append(errs, validateKeys(fmt.Sprintf("%s[%d]", path, idx), item, t.Elem())...)
+		}
+	case reflect.Map:
+		values, keys, ok := stringKeys(node)
+		if !ok {
+			return nil
+		}
+		for _, key := range keys {
+			errs = append(errs, validateKeys(joinPath(path, key), values[key], t.Elem())...)
+		}
+	}
+	return errs
+}
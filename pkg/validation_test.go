@@ -0,0 +1,410 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected []string
+	}{
+		{
+			name: "valid config",
+			config: `monitor-config:
+  check-timeout: 100
+  monitor-ranges:
+    - ip-address-start: "192.168.1.2"
+      ip-address-end: "192.168.1.10"
+      monitor-ports:
+        - port: 6443
+          name: "api"
+          path-match: "api"
+        - port: 443
+          name: "ingress-https"
+          path-prefix: "*.apps"
+`,
+		},
+		{
+			name: "unknown keys",
+			config: `monitor-config:
+  check-timout: 100
+  monitor-ranges:
+    - ip-address-start: "192.168.1.2"
+      ip-address-end: "192.168.1.10"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+          path-suffix: "api"
+`,
+			expected: []string{
+				"monitor-config.check-timout",
+				"monitor-config.monitor-ranges[0].monitor-ports[0].path-suffix",
+			},
+		},
+		{
+			name: "swapped and overlapping ranges",
+			config: `monitor-config:
+  monitor-ranges:
+    - ip-address-start: "192.168.1.10"
+      ip-address-end: "192.168.1.2"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-address-start: "192.168.2.2"
+      ip-address-end: "192.168.2.10"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-address-start: "192.168.2.10"
+      ip-address-end: "bad"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-address-start: "192.168.2.5"
+      ip-address-end: "192.168.2.20"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+`,
+			expected: []string{
+				"monitor-config.monitor-ranges[0]",
+				"monitor-config.monitor-ranges[2].ip-address-end",
+				"monitor-config.monitor-ranges[3]",
+			},
+		},
+		{
+			name: "CIDR ranges",
+			config: `monitor-config:
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      exclude: ["192.168.1.1", "192.168.1.64/26", "bad"]
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-cidr: "192.168.1.128/25"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-cidr: "2001:db8::/120"
+      ip-address-start: "2001:db8::1"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+    - ip-cidr: "2001:db8::/64"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+`,
+			expected: []string{
+				"monitor-config.monitor-ranges[0].exclude[2]",
+				"monitor-config.monitor-ranges[1]",
+				"monitor-config.monitor-ranges[2]",
+				"monitor-config.monitor-ranges[3]",
+			},
+		},
+		{
+			name: "port profiles",
+			config: `monitor-config:
+  port-profiles:
+    lab:
+      - port: 6443
+        name: "api"
+    shared:
+      - port: 6443
+        name: "api"
+        path-match: "api"
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/28"
+      port-profile: "missing"
+    - ip-cidr: "192.168.2.0/28"
+      port-profile: "lab"
+    - ip-cidr: "192.168.3.0/28"
+      port-profile: "shared"
+      port-overrides:
+        mcs:
+          port: 22623
+    - ip-cidr: "192.168.4.0/28"
+      port-profile: "shared"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+`,
+			expected: []string{
+				"monitor-config.port-profiles.lab[0]",
+				"monitor-config.monitor-ranges[0].port-profile",
+				"monitor-config.monitor-ranges[2].port-overrides.mcs",
+				"monitor-config.monitor-ranges[3].monitor-ports[0].port",
+			},
+		},
+		{
+			name: "negative scan settings",
+			config: `monitor-config:
+  scan:
+    concurrency: -1
+    probes-per-second: -1
+    deadline: -1
+    full-scan-interval: -1
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      probes-per-second: -1
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.scan.concurrency",
+				"monitor-config.scan.probes-per-second",
+				"monitor-config.scan.deadline",
+				"monitor-config.scan.full-scan-interval",
+				"monitor-config.monitor-ranges[0].probes-per-second",
+			},
+		},
+		{
+			name: "negative certificate expiry warning",
+			config: `monitor-config:
+  certificate-expiry-warning-days: -1
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.certificate-expiry-warning-days",
+			},
+		},
+		{
+			name: "domain policy",
+			config: `monitor-config:
+  domain-policy:
+    allowed-suffixes:
+      - ci.example.com
+      - "."
+    denied-domains:
+      - ""
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+      expected-domains:
+        - 'ci-op-[a-z0-9]+\.ci\.example\.com'
+        - 'ci-op-[a-z'
+`,
+			expected: []string{
+				"monitor-config.domain-policy.allowed-suffixes[1]",
+				"monitor-config.domain-policy.denied-domains[0]",
+				"monitor-config.monitor-ranges[0].expected-domains[1]",
+			},
+		},
+		{
+			name: "unknown conflict resolution",
+			config: `monitor-config:
+  conflict-resolution: last-wins
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.conflict-resolution",
+			},
+		},
+		{
+			name: "SNI routing",
+			config: `monitor-config:
+  sni-routing:
+    mode: maps
+    map-directory: haproxy
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.sni-routing.mode",
+				"monitor-config.sni-routing.map-directory",
+			},
+		},
+		{
+			name: "runtime API",
+			config: `monitor-config:
+  runtime-api:
+    address: /var/run/haproxy.sock
+    server-slots: -1
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{"monitor-config.runtime-api.server-slots"},
+		},
+		{
+			name: "Data Plane API output",
+			config: `monitor-config:
+  sni-routing:
+    mode: map
+  output:
+    type: data-plane-api
+    data-plane-api:
+      url: haproxy:5555
+      retries: -1
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.output.data-plane-api.url",
+				"monitor-config.output.data-plane-api.retries",
+				"monitor-config.output.type",
+			},
+		},
+		{
+			name: "output renderer",
+			config: `monitor-config:
+  sni-routing:
+    mode: map
+  runtime-api:
+    address: /var/run/haproxy.sock
+  output:
+    renderer: envoy
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{
+				"monitor-config.output.renderer",
+				"monitor-config.output.renderer",
+			},
+		},
+		{
+			name: "unknown output renderer",
+			config: `monitor-config:
+  output:
+    renderer: caddy
+  monitor-ranges:
+    - ip-cidr: "192.168.1.0/24"
+      port-profile: openshift-default
+`,
+			expected: []string{"monitor-config.output.renderer"},
+		},
+		{
+			name: "namespace rules",
+			config: `monitor-config:
+  namespace-rules:
+    regex: "ci-("
+    label-selector: "a in (b"
+    job-identity:
+      source: "secret"
+    cluster-domain-template: "{{.Cluster}}.{{.BaseDomain}}"
+    lookup-host-template: "{{.ClusterDomain"
+`,
+			expected: []string{
+				"monitor-config.namespace-rules.regex",
+				"monitor-config.namespace-rules.label-selector",
+				"monitor-config.namespace-rules.job-identity.source",
+				"monitor-config.namespace-rules.lookup-host-template",
+			},
+		},
+		{
+			name: "invalid ports",
+			config: `monitor-config:
+  subnets-json-path: /does/not/exist.json
+  monitor-ranges:
+    - ip-address-start: "192.168.1.2"
+      ip-address-end: "192.168.1.10"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+        - port: 6443
+          path-prefix: "*.apps"
+        - port: 70000
+          path-match: "api"
+        - port: 443
+          protocol: "gopher"
+        - port: 22
+          path-match: "api"
+          protocol: tcp
+          sni: "api.example.com"
+        - port: 22623
+          path-match: "api-int"
+          protocol: tls
+          sni: "api-int.example.com"
+        - port: 8443
+          path-match: "api"
+          domain-rules:
+            - "^api\\.(?P<domain>.+)$"
+            - "^api\\.(.+)$"
+            - "^api\\.(?P<domain>.+$"
+`,
+			expected: []string{
+				"monitor-config.subnets-json-path",
+				"monitor-config.monitor-ranges[0].monitor-ports[1].port",
+				"monitor-config.monitor-ranges[0].monitor-ports[2].port",
+				"monitor-config.monitor-ranges[0].monitor-ports[3]",
+				"monitor-config.monitor-ranges[0].monitor-ports[3].protocol",
+				"monitor-config.monitor-ranges[0].monitor-ports[4].sni",
+				"monitor-config.monitor-ranges[0].monitor-ports[6].domain-rules[1]",
+				"monitor-config.monitor-ranges[0].monitor-ports[6].domain-rules[2]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := []string{}
+			for _, validationError := range ValidateConfig([]byte(tt.config)) {
+				paths = append(paths, validationError.Path)
+			}
+			if len(tt.expected) == 0 && len(paths) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(paths, tt.expected) {
+				t.Fatalf("expected errors at %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+}
+
+func TestValidateSubnetsJsonOverlaps(t *testing.T) {
+	addresses := func(prefix string, first int) []string {
+		ips := []string{}
+		for idx := 0; idx <= subnetRangeEnd; idx++ {
+			ips = append(ips, fmt.Sprintf("%s.%d", prefix, first+idx))
+		}
+		return ips
+	}
+	subnets, err := json.Marshal(map[string]map[string]map[string][]string{
+		"dc1": {
+			"vlan1": {"ipAddresses": addresses("192.168.3", 1)},
+			"vlan2": {"ipAddresses": addresses("192.168.3", 5)},
+			"vlan3": {"ipAddresses": addresses("192.168.4", 1)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnetsPath := filepath.Join(t.TempDir(), "subnets.json")
+	if err := os.WriteFile(subnetsPath, subnets, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := fmt.Sprintf(`monitor-config:
+  subnets-json-path: %s
+  monitor-ranges:
+    - ip-address-start: "192.168.4.2"
+      ip-address-end: "192.168.4.10"
+      monitor-ports:
+        - port: 6443
+          path-match: "api"
+`, subnetsPath)
+	messages := []string{}
+	for _, validationError := range ValidateConfig([]byte(config)) {
+		messages = append(messages, validationError.Error())
+	}
+	expected := []string{
+		"monitor-config.subnets-json-path[192.168.3.5-192.168.3.15]: overlaps monitor-config.subnets-json-path[192.168.3.1-192.168.3.11]",
+		"monitor-config.subnets-json-path[192.168.4.1-192.168.4.11]: overlaps monitor-config.monitor-ranges[0]",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %v, got %v", expected, messages)
+	}
+}